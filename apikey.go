@@ -0,0 +1,14 @@
+package main
+
+import "net/http"
+
+// apiKeyEnv is the environment variable holding the API key when the flag is not set
+const apiKeyEnv = "ARL_API_KEY"
+
+// apiKeyAuthorization sends the credential as an API key in the given header
+func apiKeyAuthorization(header string) func(req *http.Request, key string) error {
+	return func(req *http.Request, key string) error {
+		req.Header.Set(header, key)
+		return nil
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apimSubscriptionKeyHeader is the header carrying the Azure API Management subscription key
+const apimSubscriptionKeyHeader = "Ocp-Apim-Subscription-Key"
+
+// apimSubscription is a subscription key labeled with its product
+type apimSubscription struct {
+	product string
+	key     string
+}
+
+// apimSubscriptions are the subscription keys measured in turn, e.g. -apim-key starter=<key> -apim-key premium=<key>
+type apimSubscriptions []apimSubscription
+
+func (as *apimSubscriptions) String() string {
+	// never print the keys
+	return strings.Join(as.products(), ",")
+}
+
+func (as *apimSubscriptions) Set(value string) error {
+	subscription := apimSubscription{key: value}
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		subscription.product = parts[0]
+		subscription.key = parts[1]
+	}
+	if subscription.key == "" {
+		return fmt.Errorf("empty subscription key, expected [<product>=]<key>")
+	}
+	if subscription.product == "" {
+		subscription.product = fmt.Sprintf("key-%d", len(*as))
+	}
+	*as = append(*as, subscription)
+	return nil
+}
+
+// products returns the labels of the subscriptions
+func (as *apimSubscriptions) products() []string {
+	var products []string
+	for _, subscription := range *as {
+		products = append(products, subscription.product)
+	}
+	return products
+}
+
+// keys returns the subscription keys in the order of their products
+func (as *apimSubscriptions) keys() []string {
+	var keys []string
+	for _, subscription := range *as {
+		keys = append(keys, subscription.key)
+	}
+	return keys
+}
+
+// subscriptionKeyAuthorization sends the credential as an API Management subscription key
+func subscriptionKeyAuthorization(req *http.Request, key string) error {
+	req.Header.Set(apimSubscriptionKeyHeader, key)
+	return nil
+}
@@ -1,177 +1,1753 @@
 package main
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/Azure/go-ntlmssp"
+	"github.com/quic-go/quic-go/http3"
 )
 
 var (
-	resource         string
-	tenantID         string
-	clientID         string
-	numTokens        int
-	parallelRequests int
+	resource                string
+	tenantID                string
+	clientID                string
+	clientSecret            string
+	clientKey               string
+	clientKeyID             string
+	authMode                string
+	identityClientID        string
+	federatedTokenFile      string
+	staticToken             string
+	staticTokenFile         string
+	tokenStdin              bool
+	tokenURL                string
+	apiKey                  string
+	apiKeyHeader            string
+	userAssertion           string
+	execCommand             string
+	loginURL                string
+	servicePrincipalName    string
+	loginData               string
+	authSettings            = make(authConfig)
+	awsService              string
+	basicUser               string
+	basicPassword           string
+	useNetrc                bool
+	gcpAudience             string
+	tokenResource           string
+	awsRegion               string
+	authorizeURL            string
+	username                string
+	password                string
+	scopes                  string
+	clientCert              string
+	certPassword            string
+	numTokens               int
+	identitiesFile          string
+	tokenAssignmentStrategy string
+	renewTokens             bool
+	parallelRequests        int
+	backendHeader           string
+	advertised              advertisedLimit
+	fleetClients            int
+	fleetHeadroom           float64
+	price                   float64
+	unitsPerRequest         float64
+	dryRun                  bool
+	rotationTokens          int
+	profiles                = make(tokenProfiles)
+	interactive             bool
+	deviceCodeJSON          bool
+	deviceCodeTimeout       time.Duration
+	tokenCachePath          string
+	noCache                 bool
+	cloudName               string
+	authorityHost           string
+	measureDuration         time.Duration
+	discover                bool
+	sweepMethods            bool
+	probeMethod             string
+	probeBody               []byte
+	bodyText                string
+	bodyFile                string
+	probeContentType        string
+	formParts               []formPart
+	params                  queryParams
+	useHTTP2                bool
+	acceptEncoding          string
+	followRedirects         int
+	acceptRedirected        bool
+	dialTimeout             time.Duration
+	handshakeTimeout        time.Duration
+	responseHeaderTimeout   time.Duration
+	requestTimeout          time.Duration
+	grpcMethod              string
+	graphqlQuery            string
+	graphqlVariables        string
+	useHTTP3                bool
+	proxyList               string
+	proxyFile               string
+	proxies                 []*url.URL
+	openAPISpec             string
+	heatmapFile             string
+	correctOmission         bool
+	compareKeepAlive        bool
+	compareConditional      bool
+	cooldown                time.Duration
+	preflight               bool
+	preflightOrigin         string
+	metadataTest            bool
+	fullHandshakes          bool
+	disableKeepAlive        bool
+	maxIdleConnsPerHost     int
+	maxSamples              int
+	secondaryHost           string
+	drainTimeout            time.Duration
+	safetyConfigPath        string
+	safetyOverride          bool
+	hookToken               bool
+	hardCapRate             float64
+	ceiling                 *hardCap
+	auditLog                string
+	audit                   *auditRecord
+	roles                   string
+	setupHook               string
+	teardownHook            string
+	preCommand              string
+	postCommand             string
+	maxConnFailures         int
+	sampleLogPath           string
+	captureBody             int64
+	requestIDs              bool
+	captureAllBodies        bool
+	successStatuses         = successCodes{"2xx"}
+	expectContains          expectedSubstrings
+	expectJSONPaths         expectedPaths
+	samplesLog              *sampleLog
+	logFile                 string
+	logMaxSize              int64
+	logMaxAge               time.Duration
+	logCompress             bool
+	loadStep                float64
+	traceFile               string
+	apimKeys                apimSubscriptions
+	exportFormat            string
+	ranges                  rangeSizes
+	listenAddr              string
+	upstream                string
+	recordFile              string
+	recordSample            float64
+	recordRedact            string
+	replayFile              string
+	sshTunnel               string
+	hmacKey                 string
+	hmacKeyID               string
+	hmacHeader              string
+	hmacFormat              string
+	hmacDateHeader          string
+	tlsCert                 string
+	tlsKey                  string
+	probeCertificate        *tls.Certificate
+	tlsSkipVerify           bool
+	caFile                  string
+	tlsMinVersion           string
+	probeRootCAs            *x509.CertPool
+	probeMinVersion         uint16
+	hostHeader              string
+	serverName              string
+	resolve                 = resolveMappings{}
+	unixSocket              string
+	sourceIPs               sourceAddresses
+	userAgent               string
+	userAgentFile           string
+	userAgents              []string
 )
 
 func init() {
+	flag.Var(&ranges, "range-sizes", "comma separated chunk sizes, e.g. 64KiB,1MiB,16MiB, of ranged GETs measured in turn to find out the limit unit")
+	flag.StringVar(&listenAddr, "listen", ":8080", "address on which 'arl record' listens for the client traffic")
+	flag.StringVar(&upstream, "upstream", "", "URL to which 'arl record' proxies the client traffic")
+	flag.StringVar(&recordFile, "record-file", "capture.json", "file receiving the traffic captured by 'arl record'")
+	flag.Float64Var(&recordSample, "record-sample", 100, "percentage of the proxied requests captured by 'arl record'")
+	flag.StringVar(&recordRedact, "record-redact", redactAll, "what 'arl record' redacts from the captured requests, 'all' (every query value and the ID-like path segments) or 'credentials' (the values of the credential-like query parameters)")
+	flag.StringVar(&replayFile, "replay", "", "replay the requests captured by 'arl record' against the resource host instead of probing the resource")
 	flag.StringVar(&resource, "resource", "", "REST resource for which the rate limit measurement is executed")
 	flag.StringVar(&tenantID, "tenant-id", "", "tenant ID")
+	flag.StringVar(&cloudName, "cloud", "public", "Azure cloud of the resource: "+cloudNames())
+	flag.StringVar(&authorityHost, "authority", "", "Azure AD authority, e.g. https://login.microsoftonline.us/ (default the authority of the cloud)")
 	flag.StringVar(&clientID, "client-id", "", "client ID")
+	flag.StringVar(&authMode, "auth", authAzure, "authentication mode, 'azure' (device code, browser, client secret or certificate), 'managed-identity', 'workload-identity', 'azure-cli', 'oauth2' (client credentials of any OAuth2 token endpoint), 'ropc' (password of a test user), 'browser' (authorization code with PKCE), 'aws-sigv4' (requests signed with the AWS credentials), 'gcp' (Google application default credentials), 'obo' (on-behalf-of exchange of a user assertion), 'exec' (token printed by the -exec-cmd), 'none' (unauthenticated requests), 'session' (cookies of a -login-url), 'ntlm' (Windows integrated authentication of the -username), 'negotiate' (Kerberos tickets of kinit) or 'chain' trying them in turn")
+	flag.StringVar(&federatedTokenFile, "federated-token-file", "", "federated token exchanged by the workload identity (default $AZURE_FEDERATED_TOKEN_FILE, or the GitHub Actions OIDC token)")
+	flag.StringVar(&staticToken, "token", "", "already acquired bearer token used instead of any authentication (default $ARL_TOKEN)")
+	flag.StringVar(&staticTokenFile, "token-file", "", "file holding an already acquired bearer token, read again whenever a token is needed")
+	flag.BoolVar(&tokenStdin, "token-stdin", false, "read one token per line from stdin, each used as a distinct identity")
+	flag.StringVar(&tokenURL, "token-url", "", "token endpoint of the OAuth2 authorization server used by -auth oauth2 and -auth browser")
+	flag.StringVar(&authorizeURL, "authorize-url", "", "authorization endpoint of the OAuth2 authorization server used by -auth browser (default the Azure AD sign in)")
+	flag.StringVar(&username, "username", "", "user signed in with its password by -auth ropc, or DOMAIN\\user authenticated by -auth ntlm")
+	flag.StringVar(&password, "password", "", "password of the user signed in by -auth ropc or -auth ntlm (default $ARL_PASSWORD)")
+	flag.StringVar(&scopes, "scopes", "", "comma separated scopes requested instead of the .default scope of the resource, e.g. https://graph.microsoft.com/.default or api://<app>/Read, by -auth azure, ropc, obo, workload-identity, oauth2, browser and gcp")
+	flag.StringVar(&apiKey, "api-key", "", "API key sent in the -api-key-header instead of a bearer token (default $ARL_API_KEY)")
+	flag.StringVar(&apiKeyHeader, "api-key-header", "X-Api-Key", "header carrying the API key")
+	flag.StringVar(&basicUser, "basic-user", "", "user sent with the HTTP basic authentication instead of a bearer token")
+	flag.StringVar(&basicPassword, "basic-pass", "", "password of the -basic-user (default $ARL_BASIC_PASS)")
+	flag.BoolVar(&useNetrc, "netrc", false, "look up the HTTP basic authentication user and password of the resource host in $NETRC or ~/.netrc")
+	flag.Var(authSettings, "auth-config", "<key>=<value> setting of a custom token source registered with tokensource.Register, can be repeated")
+	flag.StringVar(&servicePrincipalName, "spn", "", "Kerberos service principal of the resource used by -auth negotiate (default HTTP/<resource host>)")
+	flag.StringVar(&loginURL, "login-url", "", "endpoint receiving the POST of the -login-data by -auth session, the session cookies it sets authorize the probes")
+	flag.StringVar(&loginData, "login-data", "", "form (user=<user>&password=<password>) or JSON document posted to the -login-url (default $ARL_LOGIN_DATA)")
+	flag.StringVar(&execCommand, "exec-cmd", "", "credential helper run by -auth exec, printing a JSON document {\"token\": ..., \"expiry\": ...} with the token and its RFC 3339 expiry")
+	flag.StringVar(&userAssertion, "user-assertion", "", "token of the user calling the middle-tier service, exchanged by -auth obo for a token of the resource (default $ARL_USER_ASSERTION)")
+	flag.StringVar(&awsService, "aws-service", "", "AWS service signed for by -auth aws-sigv4, e.g. execute-api or s3 (default inferred from the resource host)")
+	flag.StringVar(&awsRegion, "aws-region", "", "AWS region signed for by -auth aws-sigv4 (default inferred from the resource host, or the AWS configuration)")
+	flag.StringVar(&tokenResource, "token-resource", "", "resource (audience) of the tokens when it differs from the probed host, e.g. the App ID URI of an API fronted by APIM or a custom domain (default the scheme and host of the resource)")
+	flag.StringVar(&tokenResource, "token-audience", "", "alias of -token-resource")
+	flag.StringVar(&gcpAudience, "audience", "", "audience of the identity tokens minted by -auth gcp, e.g. the Cloud Run service URL (default access tokens)")
+	flag.StringVar(&identityClientID, "msi-client-id", "", "client ID of the user assigned managed identity (default the system assigned identity)")
+	flag.StringVar(&clientCert, "client-cert", "", "PFX or PEM certificate of the service principal, or keyvault://<vault>/<certificate>")
+	flag.StringVar(&certPassword, "cert-password", "", "password of the PFX certificate (default $ARL_CERT_PASSWORD)")
+	flag.StringVar(&clientKey, "client-key", "", "PEM RSA private key signing the client assertion (JWT) which authenticates the client instead of a secret")
+	flag.StringVar(&clientKeyID, "client-key-id", "", "key ID of the -client-key, the hex thumbprint of its registered certificate for Azure AD")
+	flag.StringVar(&clientSecret, "client-secret", "", "client secret of the service principal (default $ARL_CLIENT_SECRET), or keyvault://<vault>/<secret> to fetch it with the managed identity")
+	flag.BoolVar(&interactive, "interactive", false, "sign in with the system browser instead of the device code flow, the app registration needs the http://localhost redirect URI")
+	flag.BoolVar(&deviceCodeJSON, "device-code-json", false, "print the device code payload as JSON to stdout for automation")
+	flag.DurationVar(&deviceCodeTimeout, "device-code-timeout", 0, "maximum time to wait for the device code flow completion (default no limit)")
+	flag.StringVar(&tokenCachePath, "token-cache", defaultTokenCachePath(), "file persisting the access and refresh tokens across runs, empty disables it")
+	flag.BoolVar(&noCache, "no-cache", false, "neither read nor write the token cache, forcing a new login")
+	flag.DurationVar(&measureDuration, "duration", 0, "maximum duration of the measurement (default until the rate limit is reached)")
 	flag.IntVar(&numTokens, "num-tokens", 1, "number of tokens requested for a user")
+	flag.StringVar(&tokenAssignmentStrategy, "token-assignment", assignDedicated, "assignment of the tokens to the parallel workers, 'dedicated' (a measurement per token), or the tokens shared by the workers of one measurement: 'round-robin' (the next token for every probe), 'sticky' (the same token per worker) or 'random'")
+	flag.BoolVar(&renewTokens, "renew-tokens", false, "renew the tokens in the background shortly before they expire instead of shortening the measurement to their lifetime")
+	flag.StringVar(&identitiesFile, "identities-file", "", "CSV file of identities (columns name, tenant_id, client_id, client_secret, username, password), each token acquired by a distinct principal")
 	flag.IntVar(&parallelRequests, "parallel-reqs", 8, "number of parallel request")
+	flag.Var(&apimKeys, "apim-key", "API Management subscription key, [<product>=]<key>, used instead of a token (repeatable to compare the products)")
+	flag.Var(&advertised, "advertised", "documented rate limit to verify, e.g. 1000/min")
+	flag.IntVar(&fleetClients, "clients", 0, "number of clients sharing the measured limit, enables the fleet budget plan")
+	flag.Float64Var(&fleetHeadroom, "headroom", 20, "percentage of the measured limit kept in reserve by the fleet budget plan")
+	flag.Float64Var(&price, "price", 0, "price of a single unit consumed by the API, enables the cost estimation")
+	flag.Float64Var(&unitsPerRequest, "units-per-request", 1, "number of priced units consumed by a request")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the measurement plan without sending any request")
+	flag.Var(profiles, "profile", "per token profile override, e.g. 0:name=attacker,parallel=32,rate=100 (repeatable)")
+	flag.IntVar(&rotationTokens, "rotation-test", 0, "number of fresh tokens used to check if token rotation resets a reached rate limit")
+	flag.StringVar(&probeMethod, "method", http.MethodGet, "HTTP method of the probes, e.g. POST, PUT, PATCH or DELETE which often have stricter limits")
+	flag.StringVar(&bodyText, "body", "", "body sent with every probe request")
+	flag.StringVar(&bodyFile, "body-file", "", "file with the body sent with every probe request")
+	flag.StringVar(&acceptEncoding, "accept-encoding", "gzip", "comma separated content codings requested by the probes among identity, gzip and br, e.g. identity to disable the compression")
+	flag.IntVar(&followRedirects, "follow-redirects", 0, "maximum number of redirects followed by a probe, by default a redirect is an error")
+	flag.BoolVar(&acceptRedirected, "accept-redirected", false, "count the successful responses reached through -follow-redirects as accepted requests, by default they are not, e.g. the error page of a throttled request")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 30*time.Second, "maximum time to establish the TCP connection of a probe")
+	flag.DurationVar(&handshakeTimeout, "tls-handshake-timeout", 10*time.Second, "maximum time of the TLS handshake of a probe")
+	flag.DurationVar(&responseHeaderTimeout, "response-header-timeout", 0, "maximum time to wait for the response headers once a probe is sent (default no limit)")
+	flag.DurationVar(&requestTimeout, "timeout", 10*time.Minute, "maximum total time of a probe, including the connection, redirects and the response body")
+	flag.BoolVar(&useHTTP2, "http2", true, "negotiate HTTP/2 with the TLS servers, -http2=false restricts the probes to HTTP/1.1")
+	flag.StringVar(&proxyList, "proxy", "", "http, https or socks5 proxy URL of the probes, or a comma separated list of proxies rotated across the workers (default $HTTPS_PROXY)")
+	flag.StringVar(&proxyFile, "proxy-file", "", "file with the proxy URLs rotated across the workers, one per line")
+	flag.StringVar(&grpcMethod, "grpc-method", "", "gRPC method, e.g. orders.v1.Orders/GetOrder, called by the probes on the resource with the serialized protobuf message of the -body-file")
+	flag.StringVar(&graphqlQuery, "graphql-query", "", "GraphQL document POSTed by the probes to the resource, or @<file> to read it from a file")
+	flag.StringVar(&graphqlVariables, "graphql-variables", "", "JSON object of the variables of the -graphql-query, or @<file> to read it from a file")
+	flag.BoolVar(&useHTTP3, "http3", false, "experimental, send the probes with HTTP/3 over QUIC instead of TCP")
+	flag.Var(&params, "param", "<key>=<value> query parameter appended to the probe URL, the value may contain placeholders such as {{rand}}, can be repeated")
+	flag.Var(formFlag{parts: &formParts}, "form-field", "<name>=<value> field of a multipart/form-data body sent with every probe request, can be repeated")
+	flag.Var(formFlag{parts: &formParts, file: true}, "form-file", "<name>=<path> file uploaded in a multipart/form-data body sent with every probe request, can be repeated")
+	flag.StringVar(&probeContentType, "content-type", "application/json", "content type of the -body or -body-file")
+	flag.BoolVar(&discover, "discover", false, "discover the methods supported by the resource with an OPTIONS request")
+	flag.BoolVar(&sweepMethods, "sweep-methods", false, "measure every discovered safe method (GET, HEAD, OPTIONS) in turn")
+	flag.StringVar(&openAPISpec, "openapi", "", "JSON OpenAPI spec whose safe operations are measured relative to the resource URL")
+	flag.StringVar(&exportFormat, "format", "go-ratelimiter", "format of the client configuration printed by 'arl export'")
+	flag.StringVar(&heatmapFile, "heatmap", "", "write a latency heatmap per token to this HTML (or .png) file")
+	flag.StringVar(&traceFile, "trace", "", "write the requests of all the tokens to this Chrome trace (Perfetto) JSON file")
+	flag.BoolVar(&correctOmission, "correct-omission", false, "correct the latency percentiles for coordinated omission")
+	flag.BoolVar(&compareKeepAlive, "compare-keepalive", false, "measure with connection reuse and again with a new connection per request")
+	flag.BoolVar(&preflight, "preflight", false, "send the CORS preflight OPTIONS requests of the -method, without credentials like a browser, instead of the probes")
+	flag.StringVar(&preflightOrigin, "origin", "https://localhost", "Origin header of the CORS preflight requests")
+	flag.BoolVar(&metadataTest, "metadata-test", false, "once the rate limit is reached, check whether the HEAD and the CORS preflight requests are throttled too")
+	flag.BoolVar(&compareConditional, "compare-conditional", false, "measure the GET probes again with conditional requests of the ETag of the resource answered with a 304")
+	flag.DurationVar(&cooldown, "cooldown", time.Minute, "time waited for the rate limit to reset between two measurements of the same resource when the throttled responses advertise no Retry-After")
+	flag.BoolVar(&disableKeepAlive, "disable-keepalive", false, "open a new connection for every request, still resuming the TLS sessions")
+	flag.IntVar(&maxSamples, "max-samples", 100000, "maximum number of samples of a measurement kept in memory for the latency percentiles and the trace, a random subset of the samples is kept beyond, all the samples are still appended to the -sample-log")
+	flag.IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "maximum number of idle connections kept open to the resource, the other connections are closed once their request completes")
+	flag.BoolVar(&fullHandshakes, "force-full-handshake", false, "open a new connection without TLS session resumption for every request")
+	flag.StringVar(&secondaryHost, "secondary-host", "", "secondary host to fail over to once the primary throttles")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "maximum time to wait for the in-flight probes before cancelling them")
+	flag.StringVar(&safetyConfigPath, "safety-config", defaultSafetyConfigPath(), "JSON file with the allowed and denied host patterns")
+	flag.BoolVar(&safetyOverride, "i-know-what-i-am-doing", false, "measure hosts which are denied or not allowed by the safety config")
+	flag.Float64Var(&hardCapRate, "hard-cap", 0, "absolute maximum of requests/sec sent by all the probes together (default no ceiling)")
+	flag.StringVar(&auditLog, "audit-log", defaultAuditLogPath(), "file or http(s) endpoint receiving an audit record of every run, empty disables it")
+	flag.StringVar(&roles, "roles", "", "comma separated roles, e.g. admin,reader, each measured with its own identity to compare their throttling tiers")
+	flag.StringVar(&setupHook, "setup", "", "hook run before probing, '<METHOD> <URL>' or 'exec:<command>'")
+	flag.StringVar(&teardownHook, "teardown", "", "hook run after probing, '<METHOD> <URL>' or 'exec:<command>'")
+	flag.BoolVar(&hookToken, "hook-token", false, "send the token of the measurement with the HTTP -setup and -teardown hooks, their hosts must pass the safety config")
+	flag.StringVar(&preCommand, "pre-cmd", "", "shell command run before the measurement with the run metadata in ARL_* variables")
+	flag.StringVar(&postCommand, "post-cmd", "", "shell command run after the measurement, ARL_RESULT_PATH points to the JSON result")
+	flag.IntVar(&maxConnFailures, "max-conn-failures", 100, "number of connection level failures (TLS, refused, reset, timeout) after which the measurement stops")
+	flag.StringVar(&sampleLogPath, "sample-log", "", "NDJSON file receiving every probe sample")
+	flag.BoolVar(&requestIDs, "request-id", false, "send a new UUID in the x-ms-client-request-id and X-Request-ID headers of every probe, recorded in the -sample-log")
+	flag.Int64Var(&captureBody, "capture-body", 0, "number of bytes of the beginning of the unsuccessful response bodies captured in the -sample-log")
+	flag.Var(&successStatuses, "success-codes", "comma separated status codes or classes of the accepted requests, e.g. 200,201,202,204")
+	flag.Var(&expectContains, "expect-body-contains", "text expected in the successful response bodies, a successful response without it counts as a failure, can be repeated")
+	flag.Var(&expectJSONPaths, "expect-jsonpath", "JSONPath, e.g. $.id, expected to have a value in the successful response bodies, or a given value with $.value[0].state=Succeeded, can be repeated")
+	flag.BoolVar(&captureAllBodies, "capture-all-bodies", false, "capture the beginning of the successful response bodies too")
+	flag.StringVar(&logFile, "log-file", "", "file receiving the log output instead of stderr")
+	flag.Int64Var(&logMaxSize, "log-max-size", 100, "size in MB after which the sample log and the log file are rotated, 0 disables it")
+	flag.DurationVar(&logMaxAge, "log-max-age", 24*time.Hour, "age after which the sample log and the log file are rotated, 0 disables it")
+	flag.BoolVar(&logCompress, "log-compress", false, "gzip the rotated sample log and log files")
+	flag.StringVar(&hmacKey, "hmac-key", "", "shared key signing the method, path and date of the probes with HMAC-SHA256, base64:<key> for a base64 encoded key (default $ARL_HMAC_KEY)")
+	flag.StringVar(&hmacKeyID, "hmac-key-id", "", "key or account identifier inserted as {id} in the -hmac-format")
+	flag.StringVar(&hmacHeader, "hmac-header", "Authorization", "header receiving the HMAC signature")
+	flag.StringVar(&hmacFormat, "hmac-format", "HMAC {id}:{signature}", "format of the HMAC signature header, {id}, {signature} and {date} are replaced")
+	flag.StringVar(&hmacDateHeader, "hmac-date-header", "Date", "header carrying the date covered by the HMAC signature, e.g. x-ms-date")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM client certificate presented by the probes to the gateways enforcing mTLS")
+	flag.StringVar(&hostHeader, "host-header", "", "Host header of the probes, e.g. the production hostname of a single backend probed by its IP")
+	flag.Var(resolve, "resolve", "<host>:<port>:<ip> mapping connecting the probes of the host and port to the IP instead of resolving it, can be repeated")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent header of the probes (default the one of Go)")
+	flag.StringVar(&userAgentFile, "user-agent-file", "", "file with the User-Agent headers rotated across the workers, one per line, after the -user-agent")
+	flag.Var(&sourceIPs, "source-ip", "local IP the probe connections are bound to, repeatable or comma separated to rotate the IPs across the workers")
+	flag.StringVar(&unixSocket, "unix-socket", "", "path of the unix domain socket the probes connect to instead of the host of the resource, e.g. /var/run/docker.sock")
+	flag.StringVar(&serverName, "sni", "", "TLS server name sent and verified by the probes (default the hostname of the -host-header, or of the resource)")
+	flag.BoolVar(&tlsSkipVerify, "tls-skip-verify", false, "accept any certificate of the resource, e.g. the self-signed certificates of a staging environment")
+	flag.StringVar(&caFile, "ca-file", "", "PEM bundle of the CA certificates trusted in addition to the system roots, e.g. a private CA")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "1.2", "minimum TLS version negotiated by the probes, 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key of the -tls-cert (default the key in the certificate file)")
+	flag.StringVar(&sshTunnel, "ssh-tunnel", "", "jump host, e.g. user@bastion, through which the probes are tunneled with ssh")
+	flag.Float64Var(&loadStep, "load-step", 50, "percentage by which SIGUSR2 increases and SIGUSR1 decreases the parallelism and rate of a running measurement")
+	flag.StringVar(&backendHeader, "backend-header", "", "response header identifying the backend which served the request")
 
-	flag.Parse()
+}
 
+// parseFlags parses and validates the command line, the subcommand precedes its flags, e.g. arl auth login -resource
+// ..., which are validated like the other ones
+func parseFlags() {
+	command = commandName(os.Args[1:])
+	err := flag.CommandLine.Parse(os.Args[1+len(command):])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if noCache {
+		tokenCachePath = ""
+	}
+	err = selectCloud(cloudName, authorityHost)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if numTokens < 1 {
 		log.Fatal("number of tokens requested for a use must be at least 1")
 	}
-}
-
-func fetchTokens(tokenSource TokenSource, num int) ([]string, error) {
-	token, err := tokenSource.Token()
+	if !contains(assignmentStrategies, tokenAssignmentStrategy) {
+		log.Fatalf("unknown token assignment %q, expected one of %s", tokenAssignmentStrategy, strings.Join(assignmentStrategies, ", "))
+	}
+	probeMethod = strings.ToUpper(probeMethod)
+	if probeMethod == "" || strings.ContainsAny(probeMethod, " \t/") {
+		log.Fatalf("invalid HTTP method %q", probeMethod)
+	}
+	if sweepMethods && probeMethod != http.MethodGet {
+		log.Fatal("-method and -sweep-methods cannot be combined")
+	}
+	if useHTTP3 && (sshTunnel != "" || fullHandshakes || disableKeepAlive || compareKeepAlive) {
+		log.Fatal("-http3 cannot be combined with -ssh-tunnel, -force-full-handshake, -disable-keepalive or -compare-keepalive")
+	}
+	if grpcMethod != "" && (probeMethod != http.MethodGet || discover || sweepMethods || openAPISpec != "" || !useHTTP2 || useHTTP3) {
+		log.Fatal("-grpc-method cannot be combined with -method, -discover, -sweep-methods, -openapi, -http2=false or -http3")
+	}
+	acceptEncoding, err = parseAcceptEncoding(acceptEncoding)
 	if err != nil {
-		return nil, err
+		log.Fatal(err)
+	}
+	if dialTimeout < 0 || handshakeTimeout < 0 || responseHeaderTimeout < 0 || requestTimeout < 0 {
+		log.Fatal("the timeouts cannot be negative")
+	}
+	if followRedirects < 0 {
+		log.Fatal("the number of followed redirects cannot be negative")
+	}
+	if acceptRedirected && followRedirects == 0 {
+		log.Fatal("-accept-redirected requires -follow-redirects")
+	}
+	if maxSamples < 1 {
+		log.Fatal("the maximum number of samples must be at least 1")
+	}
+	if maxIdleConnsPerHost < 1 {
+		log.Fatal("the maximum number of idle connections per host must be at least 1")
+	}
+	proxies, err = loadProxies(proxyList, proxyFile)
+	if err != nil {
+		log.Fatalf("failed to load the proxies: %v", err)
+	}
+	if len(proxies) > 0 && (sshTunnel != "" || useHTTP3) {
+		log.Fatal("the proxies cannot be combined with -ssh-tunnel or -http3")
+	}
+	if len(resolve) > 0 && (len(proxies) > 0 || useHTTP3) {
+		log.Fatal("-resolve cannot be combined with the proxies or -http3")
+	}
+	userAgents, err = loadUserAgents(userAgent, userAgentFile)
+	if err != nil {
+		log.Fatalf("failed to load the User-Agent headers: %v", err)
+	}
+	if len(sourceIPs) > 0 && (sshTunnel != "" || unixSocket != "" || len(proxies) > 0 || useHTTP3) {
+		log.Fatal("-source-ip cannot be combined with -ssh-tunnel, -unix-socket, -proxy, -proxy-file or -http3")
+	}
+	if unixSocket != "" && (sshTunnel != "" || len(proxies) > 0 || useHTTP3 || len(resolve) > 0) {
+		log.Fatal("-unix-socket cannot be combined with -ssh-tunnel, the proxies, -http3 or -resolve")
+	}
+	if bodyText != "" && bodyFile != "" {
+		log.Fatal("-body and -body-file cannot be combined")
+	}
+	if bodyText != "" {
+		probeBody = []byte(bodyText)
+	}
+	if bodyFile != "" {
+		probeBody, err = ioutil.ReadFile(bodyFile)
+		if err != nil {
+			log.Fatalf("failed to read the body file: %v", err)
+		}
+	}
+	if graphqlVariables != "" && graphqlQuery == "" {
+		log.Fatal("-graphql-variables requires -graphql-query")
+	}
+	if graphqlQuery != "" {
+		if probeBody != nil || grpcMethod != "" || probeMethod != http.MethodGet || discover || sweepMethods || openAPISpec != "" {
+			log.Fatal("-graphql-query cannot be combined with -body, -body-file, -grpc-method, -method, -discover, -sweep-methods or -openapi")
+		}
+		probeBody, err = graphqlBody(graphqlQuery, graphqlVariables)
+		if err != nil {
+			log.Fatalf("failed to load the GraphQL operation: %v", err)
+		}
+		probeContentType = "application/json"
+	}
+	if len(formParts) > 0 {
+		if probeBody != nil || grpcMethod != "" || discover || sweepMethods || openAPISpec != "" {
+			log.Fatal("-form-field and -form-file cannot be combined with -body, -body-file, -graphql-query, -grpc-method, -discover, -sweep-methods or -openapi")
+		}
+		probeBody, probeContentType, err = multipartBody(formParts)
+		if err != nil {
+			log.Fatalf("failed to build the multipart body: %v", err)
+		}
+		if probeMethod == http.MethodGet {
+			probeMethod = http.MethodPost
+		}
+	}
+	if compareConditional && (probeMethod != http.MethodGet || grpcMethod != "" || graphqlQuery != "" || sweepMethods) {
+		log.Fatal("-compare-conditional requires GET probes, it cannot be combined with -method, -form-file, -form-field, -grpc-method, -graphql-query or -sweep-methods")
+	}
+	if probeMethod == http.MethodHead && probeBody != nil {
+		log.Fatal("the HEAD probes cannot have a body")
+	}
+	if preflight && (probeBody != nil || grpcMethod != "" || compareConditional || discover || sweepMethods || openAPISpec != "") {
+		log.Fatal("-preflight cannot be combined with a body, -grpc-method, -compare-conditional, -discover, -sweep-methods or -openapi")
+	}
+	if metadataTest && (grpcMethod != "" || graphqlQuery != "" || preflight) {
+		log.Fatal("-metadata-test cannot be combined with -grpc-method, -graphql-query or -preflight")
+	}
+	templates := []string{params.apply(resource)}
+	if !isMultipart(probeContentType) {
+		templates = append(templates, string(probeBody))
+	}
+	for _, text := range templates {
+		if _, err := parseTemplate(text); err != nil {
+			log.Fatalf("invalid template %q: %v", text, err)
+		}
+	}
+	if price < 0 || unitsPerRequest < 0 {
+		log.Fatal("price and units per request cannot be negative")
 	}
+	if hardCapRate < 0 {
+		log.Fatal("hard cap cannot be negative")
+	}
+	ceiling = newHardCap(hardCapRate)
+	if fleetHeadroom < 0 || fleetHeadroom >= 100 {
+		log.Fatal("headroom must be a percentage between 0 and 100")
+	}
+	if recordSample <= 0 || recordSample > 100 {
+		log.Fatal("record sample must be a percentage between 0 and 100")
+	}
+	if !contains(redactionModes, recordRedact) {
+		log.Fatalf("unknown redaction %q, expected one of %s", recordRedact, strings.Join(redactionModes, ", "))
+	}
+	if loadStep <= 0 {
+		log.Fatal("load step must be a positive percentage")
+	}
+	if captureBody < 0 {
+		log.Fatal("the number of captured bytes cannot be negative")
+	}
+	if (captureBody > 0 || captureAllBodies) && sampleLogPath == "" {
+		log.Fatal("-capture-body and -capture-all-bodies require a -sample-log")
+	}
+	if logMaxSize < 0 || logMaxAge < 0 {
+		log.Fatal("log rotation size and age cannot be negative")
+	}
+	if tlsCert != "" {
+		certificate, err := tls.LoadX509KeyPair(tlsCert, firstNonEmpty(tlsKey, tlsCert))
+		if err != nil {
+			log.Fatalf("failed to load the TLS client certificate: %v", err)
+		}
+		probeCertificate = &certificate
+	}
+	if caFile != "" {
+		probeRootCAs, err = loadCABundle(caFile)
+		if err != nil {
+			log.Fatalf("failed to load the CA bundle: %v", err)
+		}
+	}
+	probeMinVersion, err = parseTLSVersion(tlsMinVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if serverName == "" && hostHeader != "" {
+		serverName = hostHeader
+		if host, _, err := net.SplitHostPort(hostHeader); err == nil {
+			serverName = host
+		}
+	}
+	if tlsSkipVerify {
+		log.Printf("Warning: the certificate of the resource is not verified")
+	}
+}
 
-	var tokens []string
-	tokens = append(tokens, token)
+// openLog opens a log file rotated according to the flags
+func openLog(path string) (*rotatingFile, error) {
+	return openRotatingFile(path, logMaxSize*1024*1024, logMaxAge, logCompress)
+}
 
-	for i := 2; i <= num; i++ {
-		token, err := tokenSource.Refresh()
-		if err != nil {
-			return nil, err
+// newProbeClient creates the HTTP client of the probes, without keep-alive every request opens a new connection
+// and without session resumption every new connection performs a full TLS handshake
+func newProbeClient(keepAlive bool, resumeSessions bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = !keepAlive
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	if transport.MaxIdleConns < maxIdleConnsPerHost {
+		transport.MaxIdleConns = maxIdleConnsPerHost
+	}
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = handshakeTimeout
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	if sshTunnel != "" {
+		transport.Proxy = nil
+		transport.DialContext = sshTunnelDialer(sshTunnel)
+	}
+	if len(resolve) > 0 {
+		transport.DialContext = resolve.dialer(transport.DialContext)
+	}
+	if unixSocket != "" {
+		// every connection goes to the socket, the resource URL only provides the path and the Host header
+		transport.Proxy = nil
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", unixSocket)
 		}
-		tokens = append(tokens, token)
 	}
+	if len(proxies) > 0 {
+		transport.Proxy = rotateProxies(proxies)
+	}
+	if !useHTTP2 {
+		disableHTTP2(transport)
+	}
+	if grpcMethod != "" {
+		transport.Protocols = grpcProtocols()
+	}
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: tlsSkipVerify,
+		RootCAs:            probeRootCAs,
+		MinVersion:         probeMinVersion,
+		ServerName:         serverName,
+	}
+	if resumeSessions {
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if probeCertificate != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*probeCertificate}
+	}
+	var roundTripper http.RoundTripper = transport
+	if len(sourceIPs) > 0 {
+		roundTripper = bindSourceIPs(transport, sourceIPs)
+	}
+	if useHTTP3 {
+		// experimental, the QUIC connections are always reused
+		roundTripper = &http3.RoundTripper{TLSClientConfig: transport.TLSClientConfig}
+	}
+	if authMode == authNTLM {
+		// the NTLM handshake answers the challenge of the server with the basic credentials of the request, under
+		// the NTLM or the Negotiate scheme
+		roundTripper = ntlmssp.Negotiator{RoundTripper: roundTripper}
+	}
+	return &http.Client{
+		Transport:     roundTripper,
+		Timeout:       requestTimeout,
+		CheckRedirect: checkRedirect,
+	}
+}
 
-	return tokens, nil
+// probeTarget is the request sent by the probes
+type probeTarget struct {
+	client *http.Client
+	method string
+	URL    string
+	// header is added to every request of the probe
+	header http.Header
+	// body is sent with every request of the probe, nil for an empty request
+	body []byte
+	// accepted are the status codes of the accepted requests when they differ from the -success-codes
+	accepted successCodes
 }
 
-func get(URL string, token string) (int, error) {
-	client := &http.Client{
-		Timeout: time.Minute * 10,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return errors.New("redirect not allowed")
-		},
+// resourceTarget returns the target of the resource with the body of the flags
+func resourceTarget(client *http.Client, method string) probeTarget {
+	var target probeTarget
+	if grpcMethod != "" {
+		target = grpcTarget(client, grpcMethod, probeBody)
+	} else {
+		target = probeTarget{client: client, method: method, URL: params.apply(resource), header: http.Header{}}
+		if graphqlQuery != "" {
+			target.method = http.MethodPost
+		}
+		if probeBody != nil {
+			target.header.Set("Content-Type", probeContentType)
+			target.body = probeBody
+		}
 	}
+	if hostHeader != "" {
+		target.header.Set("Host", hostHeader)
+	}
+	if preflight {
+		return preflightTarget(target, preflightOrigin)
+	}
+	return target
+}
+
+const (
+	// errorBodyLimit is the maximum number of bytes of an error response body kept for diagnosis
+	errorBodyLimit = 4096
+	// inspectBodyLimit is the maximum number of bytes of a successful response body inspected for the GraphQL errors
+	// or the expected content
+	inspectBodyLimit = 1024 * 1024
+)
+
+// probeResponse is a response with an already consumed and closed body
+type probeResponse struct {
+	*http.Response
+	requestBytes  int64
+	responseBytes int64
+	// bodyBytes is the size of the body on the wire and decompressedBytes its size once decompressed
+	bodyBytes         int64
+	decompressedBytes int64
+	// errorBody is the beginning of the body of an error response
+	errorBody []byte
+	// accepted is true when the status code is one of the accepted codes of the target
+	accepted bool
+	// unexpected is why the body of a successful response does not match the expectations
+	unexpected string
+	// redirects is the number of redirects followed to get the response
+	redirects int
+	// requestID is the correlation ID sent with the request
+	requestID string
+	// captured is the beginning of the body captured for the sample log
+	captured []byte
+}
 
-	req, err := http.NewRequest(http.MethodGet, URL, nil)
+// send executes the probe request and returns its response
+func send(ctx context.Context, target probeTarget, token string) (*probeResponse, error) {
+	target, err := renderTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if target.body != nil {
+		body = bytes.NewReader(target.body)
+	}
+	ctx, redirects := withRedirectCount(ctx)
+	req, err := http.NewRequestWithContext(ctx, target.method, target.URL, body)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	for name, values := range target.header {
+		req.Header[name] = values
+	}
+	// the Host header is sent from the request host, e.g. the production hostname of a backend probed by its IP
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+		req.Header.Del("Host")
+	}
+	if userAgent := contextUserAgent(ctx); userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	// the correlation ID matches the probe with the logs of the service
+	var requestID string
+	if requestIDs {
+		requestID, err = newUUID()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-ms-client-request-id", requestID)
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	// an explicit Accept-Encoding stops the transport from requesting and decompressing gzip on its own, the body is
+	// decompressed by decodeBody and counted as received on the wire
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	client := target.client
+	if !isPreflight(req) {
+		err = authorizeRequest(req, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authorize the request: %v", err)
+		}
+		client = sessionClient(client, token)
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode, nil
+	wire := &countingReader{reader: resp.Body}
+	decoded, err := decodeBody(wire, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress the response: %v", err)
+	}
+	var captured []byte
+	if captureBody > 0 && (captureAllBodies || !target.accepts(resp.StatusCode)) {
+		captured, err = ioutil.ReadAll(io.LimitReader(decoded, captureBody))
+		if err != nil {
+			return nil, err
+		}
+		// the captured beginning is read again with the rest of the body
+		decoded = io.MultiReader(bytes.NewReader(captured), decoded)
+	}
+	var errorBody []byte
+	if resp.StatusCode >= http.StatusBadRequest {
+		errorBody, err = ioutil.ReadAll(io.LimitReader(decoded, errorBodyLimit))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// a GraphQL API reports the throttled operations as errors of a successful response
+	var inspected []byte
+	if (graphqlQuery != "" || expectBodies()) && resp.StatusCode < http.StatusBadRequest {
+		inspected, err = ioutil.ReadAll(io.LimitReader(decoded, inspectBodyLimit))
+		if err != nil {
+			return nil, err
+		}
+	}
+	bodyBytes, err := io.Copy(ioutil.Discard, decoded)
+	if err != nil {
+		return nil, err
+	}
+	decompressedBytes := int64(len(errorBody)+len(inspected)) + bodyBytes
+	if graphqlThrottled(inspected) {
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Status = "429 " + http.StatusText(http.StatusTooManyRequests)
+		errorBody = inspected
+		if len(errorBody) > errorBodyLimit {
+			errorBody = errorBody[:errorBodyLimit]
+		}
+	}
+	var unexpected string
+	if target.accepts(resp.StatusCode) && hasBody(req, resp.StatusCode) && expectBodies() {
+		unexpected = unexpectedBody(inspected)
+	}
+	// the gRPC status is only known once the trailers are read
+	if message := translateGRPCStatus(resp); message != "" {
+		errorBody = []byte(message)
+	}
+	// a redirect chain ending in a success may well be the error page of a throttled request
+	accepted := target.accepts(resp.StatusCode) && (*redirects == 0 || acceptRedirected)
+	return &probeResponse{
+		Response:      resp,
+		requestBytes:  requestSize(req),
+		responseBytes: responseHeaderSize(resp) + wire.count,
+		bodyBytes:     wire.count,
+		errorBody:     errorBody,
+		accepted:      accepted,
+		unexpected:    unexpected,
+
+		decompressedBytes: decompressedBytes,
+		redirects:         *redirects,
+		captured:          captured,
+		requestID:         requestID,
+	}, nil
+}
+
+// parseRetryAfter parses the Retry-After header which is either a number of seconds or an HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
 }
 
 type ratelimitProbe struct {
-	URL   string
-	token string
+	target   probeTarget
+	token    string
+	intended time.Time
 }
 
-func measureRatelimit(URL string, token string, parallelRequests int, abort chan struct{}) {
+// measurement is the outcome of a rate limit measurement
+type measurement struct {
+	accepted  uint64
+	elapsed   time.Duration
+	throttled bool
+	// retryAfter is the recovery time advertised by the first throttled response
+	retryAfter time.Duration
+}
+
+// rate returns the accepted requests per second
+func (m measurement) rate() float64 {
+	if m.elapsed <= 0 {
+		return 0
+	}
+	return float64(m.accepted) / m.elapsed.Seconds()
+}
+
+// measureRatelimit probes the URL until the rate limit is reached
+func measureRatelimit(target probeTarget, token string, profile tokenProfile, barrier *startBarrier, abort chan struct{}) measurement {
+	return measureAssigned(target, newTokenAssignment(assignDedicated, []string{token}), profile, barrier, abort)
+}
+
+// measureAssigned probes the URL until the rate limit is reached, the workers authorize the probes with the tokens of
+// the assignment
+func measureAssigned(target probeTarget, tokens *tokenAssignment, profile tokenProfile, barrier *startBarrier, abort chan struct{}) measurement {
+	parallelRequests := profile.parallel
 	ratelimitProbes := make(chan ratelimitProbe, parallelRequests)
 	ratelimitReached := make(chan struct{})
-	errorChan := make(chan error)
+	var ratelimitOnce sync.Once
+	var retryAfter time.Duration
+	errorChan := make(chan error, parallelRequests)
+	consistency := newHeaderConsistency(parallelRequests)
+	defer consistency.report()
+	throttles := newThrottleClasses()
+	defer throttles.report()
+	authFailures := newAuthFailures()
+	defer func() { authFailures.report(profile.name, tokens.first()) }()
+	unexpected := newUnexpectedBodies()
+	defer unexpected.report(profile.name)
+	failures := newConnFailures()
+	defer func() { failures.report(profile.name, barrier.start) }()
+	events := &timeline{}
+	defer func() { events.report(profile.name, barrier.start) }()
+	samples := newSampleRecorder(profile.name, samplesLog)
+	defer func() {
+		recorded, totals := samples.snapshot()
+		if totals.count > int64(len(recorded)) {
+			log.Printf("Percentiles of %s computed from a random subset of %d of the %d samples", profile.name, len(recorded), totals.count)
+		}
+		reportLatency(profile.name, recorded, correctOmission)
+		reportServerTiming(profile.name, recorded)
+		reportBandwidth(profile.name, totals, time.Since(barrier.start))
+		reportCompression(profile.name, totals)
+		reportProtocols(profile.name, totals)
+		reportRedirects(profile.name, totals)
+		reportSourceIPs(profile.name, totals)
+		reportRotated("User-Agent", profile.name, userAgents, totals.userAgents)
+		if traceFile != "" {
+			requestTrace.add(profile.name, barrier.start, recorded, events.snapshot())
+			err := requestTrace.write(traceFile)
+			if err != nil {
+				log.Printf("failed to write the request trace: %v", err)
+			}
+		}
+		if heatmapFile == "" {
+			return
+		}
+		err := writeHeatmap(heatmapFile, profile.name, samples.heatmap())
+		if err != nil {
+			log.Printf("failed to write the latency heatmap: %v", err)
+		}
+	}()
+
+	handshakes := &tlsHandshakes{}
+	traceCtx := httptrace.WithClientTrace(context.Background(), handshakes.trace())
+	defer func() { handshakes.report(profile.name, time.Since(barrier.start)) }()
 
 	var numReqs uint64
+	var numSent uint64
+	defer func() { reportCost(atomic.LoadUint64(&numSent)) }()
+	probeCtx, cancelProbes := context.WithCancel(traceCtx)
+	defer cancelProbes()
+	drain := &inFlightDrain{}
 	var wg sync.WaitGroup
-	defer wg.Wait()
+	defer drain.wait(&wg, cancelProbes, drainTimeout, profile.name)
 
-	start := time.Now()
-	for i := 0; i < parallelRequests; i++ {
-		wg.Add(1)
-		go func() {
-			for probe := range ratelimitProbes {
-				httpStatus, err := get(probe.URL, probe.token)
-				if err != nil {
-					errorChan <- err
-				} else if httpStatus == http.StatusOK {
-					atomic.AddUint64(&numReqs, 1)
-				} else if httpStatus == http.StatusTooManyRequests {
+	// a worker exits for every value received from retire when the parallelism is scaled down
+	retire := make(chan struct{})
+	worker := func(id int) {
+		defer wg.Done()
+		workerCtx := withWorker(probeCtx, id)
+		for {
+			var probe ratelimitProbe
+			select {
+			case <-retire:
+				return
+			case next, ok := <-ratelimitProbes:
+				if !ok {
+					return
+				}
+				probe = next
+			}
+			probe.token = tokens.token(id)
+			if ceiling.wait(probeCtx) != nil {
+				continue
+			}
+			if !drain.begin() {
+				continue
+			}
+			sent := time.Now()
+			resp, err := send(workerCtx, probe.target, probe.token)
+			drain.end(err)
+			if category := classifyConnFailure(err); category != "" {
+				count, total := failures.record(category)
+				if count == 1 {
+					events.add("first %s", category)
+				}
+				if total < maxConnFailures {
+					continue
+				}
+				err = fmt.Errorf("%d connection failures, last one: %v", total, err)
+			}
+			if err != nil {
+				select {
+				case errorChan <- err:
+				default:
+				}
+				continue
+			}
+			atomic.AddUint64(&numSent, 1)
+			samples.record(sample{
+				intended: probe.intended,
+				start:    sent,
+				latency:  time.Since(sent),
+				server:   serverTiming(resp.Header),
+				status:   resp.StatusCode,
+				accepted: resp.accepted,
+
+				requestBytes:  resp.requestBytes,
+				responseBytes: resp.responseBytes,
+				bodyBytes:     resp.bodyBytes,
+
+				decompressedBytes: resp.decompressedBytes,
+				redirects:         resp.redirects,
+				location:          redirectLocation(resp),
+				body:              resp.captured,
+				requestID:         resp.requestID,
+				source:            sourceIPs.worker(id),
+				userAgent:         workerUserAgent(id),
+				proto:             resp.Proto,
+			})
+			if resp.accepted && resp.unexpected != "" {
+				if unexpected.record(resp.unexpected) == 1 {
+					events.add("first unexpected body: %s", resp.unexpected)
+				}
+			} else if resp.accepted {
+				atomic.AddUint64(&numReqs, 1)
+				consistency.record(resp.Response)
+			} else if isThrottled(resp.Response) {
+				delay := throttles.record(resp.Response)
+				ratelimitOnce.Do(func() {
+					retryAfter = delay
 					close(ratelimitReached)
+				})
+			} else if isAuthFailure(resp.StatusCode) {
+				if authFailures.record(resp, probe.token) == 1 {
+					events.add("first %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
 				}
-				wg.Done()
 			}
-		}()
+		}
+	}
+	for i := 0; i < parallelRequests; i++ {
+		wg.Add(1)
+		go worker(i)
+	}
+
+	// an unlimited profile dispatches probes as soon as a worker is available
+	unlimited := make(chan time.Time)
+	close(unlimited)
+	var pace <-chan time.Time = unlimited
+	adjustments := load.subscribe()
+	defer load.unsubscribe(adjustments)
+
+	// all workers are running, wait for the other measurements before dispatching probes
+	start := barrier.wait()
+	samples.begin(start)
+	events.add("measurement started")
+	var schedule *pacer
+	if profile.rate > 0 {
+		schedule = newPacer(start, profile.rate)
+		defer schedule.stop()
+		pace = schedule.timer.C
+	}
+
+	var deadline <-chan time.Time
+	// expired is closed when the renewal fails and the tokens expire
+	var expired chan struct{}
+	runDuration := profile.duration
+	if renewTokens && tokens.renewable() {
+		stopRenewal := make(chan struct{})
+		defer close(stopRenewal)
+		expired = make(chan struct{})
+		go tokens.renew(profile.name, events, stopRenewal, expired)
+	} else if expiry, ok := tokens.expiry(); ok {
+		lifetime := expiry.Sub(start)
+		if runDuration > 0 && lifetime < runDuration {
+			log.Printf("Token of %s expires in %v, shortening the measurement from %v", profile.name, lifetime, runDuration)
+			events.add("measurement shortened to the token expiry")
+			runDuration = lifetime
+		} else if lifetime < expiryWarning {
+			log.Printf("Token of %s expires in %v, the measurement may be truncated", profile.name, lifetime)
+		}
+		if runDuration == 0 {
+			expiryTimer := time.AfterFunc(lifetime, func() {
+				log.Printf("Token of %s expired during the measurement", profile.name)
+				events.add("token expired")
+			})
+			defer expiryTimer.Stop()
+		}
+	}
+	if runDuration > 0 {
+		timer := time.NewTimer(runDuration)
+		defer timer.Stop()
+		deadline = timer.C
 	}
 
 	for {
 		select {
 		case <-ratelimitReached:
 			end := time.Now()
+			events.add("rate limit reached")
 			close(ratelimitProbes)
 			currentNumReqs := atomic.SwapUint64(&numReqs, 0)
 			ratelimitDuration := end.Sub(start)
-			log.Printf("Rate limit reached at: %4.2f request/sec\n", float64(currentNumReqs)/ratelimitDuration.Seconds())
-			return
+			log.Printf("Rate limit reached for %s at: %4.2f request/sec\n", profile.name, float64(currentNumReqs)/ratelimitDuration.Seconds())
+			verifySLA(&advertised, currentNumReqs, ratelimitDuration, true)
+			planFleet(fleetClients, fleetHeadroom, currentNumReqs, ratelimitDuration, retryAfter)
+			return measurement{currentNumReqs, ratelimitDuration, true, retryAfter}
 		case <-abort:
+			events.add("measurement aborted")
 			close(ratelimitProbes)
-			log.Println("Aborting before reaching the rate limit")
-			return
+			log.Printf("Aborting before reaching the rate limit for %s", profile.name)
+			result := measurement{atomic.LoadUint64(&numReqs), time.Since(start), false, 0}
+			verifySLA(&advertised, result.accepted, result.elapsed, false)
+			return result
+		case <-deadline:
+			events.add("measurement duration elapsed")
+			close(ratelimitProbes)
+			log.Printf("Measurement duration elapsed before reaching the rate limit for %s", profile.name)
+			result := measurement{atomic.LoadUint64(&numReqs), time.Since(start), false, 0}
+			verifySLA(&advertised, result.accepted, result.elapsed, false)
+			return result
+		case <-expired:
+			events.add("measurement stopped at the token expiry")
+			close(ratelimitProbes)
+			log.Printf("Stopping the measurement of %s, its tokens could not be renewed and expired", profile.name)
+			result := measurement{atomic.LoadUint64(&numReqs), time.Since(start), false, 0}
+			verifySLA(&advertised, result.accepted, result.elapsed, false)
+			return result
 		case probeErr := <-errorChan:
+			events.add("probe failed: %v", probeErr)
 			close(ratelimitProbes)
 			log.Printf("failed to execute the rate limit probe: %v", probeErr)
-			return
-		default:
-			ratelimitProbes <- ratelimitProbe{URL, token}
+			return measurement{atomic.LoadUint64(&numReqs), time.Since(start), false, 0}
+		case factor := <-adjustments:
+			scaled := scaleParallelism(parallelRequests, factor)
+			for ; parallelRequests < scaled; parallelRequests++ {
+				wg.Add(1)
+				go worker(parallelRequests)
+			}
+			if parallelRequests > scaled {
+				go func(count int) {
+					for i := 0; i < count; i++ {
+						select {
+						case retire <- struct{}{}:
+						case <-probeCtx.Done():
+							return
+						}
+					}
+				}(parallelRequests - scaled)
+				parallelRequests = scaled
+			}
+			if schedule != nil {
+				profile.rate *= factor
+				schedule.setRate(profile.rate)
+			}
+			log.Printf("Load of %s adjusted to %d parallel requests, rate %s", profile.name, parallelRequests, formatRate(profile.rate))
+			events.add("load adjusted to %d parallel requests", parallelRequests)
+		case <-pace:
+			// the closed-loop probes of an unlimited profile have no intended send time
+			var intended time.Time
+			if schedule != nil {
+				intended = schedule.advance()
+			}
+			ratelimitProbes <- ratelimitProbe{target: replay.target(target), intended: intended}
 		}
 	}
 }
 
-func main() {
+// authentication modes selected with -auth
+const (
+	authAzure            = "azure"
+	authManagedIdentity  = "managed-identity"
+	authWorkloadIdentity = "workload-identity"
+	authAzureCLI         = "azure-cli"
+	authChain            = "chain"
+	authOAuth2           = "oauth2"
+	authROPC             = "ropc"
+	authBrowser          = "browser"
+	authSigV4            = "aws-sigv4"
+	authGCP              = "gcp"
+	authOBO              = "obo"
+	authExec             = "exec"
+	authNone             = "none"
+	authSession          = "session"
+	authNTLM             = "ntlm"
+	authNegotiate        = "negotiate"
+)
+
+// resourceAudience returns the audience of the tokens, the -token-resource or by default the scheme and host of the
+// resource
+func resourceAudience() (string, error) {
+	if tokenResource != "" {
+		return tokenResource, nil
+	}
 	resourceURL, err := url.ParseRequestURI(resource)
 	if err != nil {
-		log.Fatalf("failed to parse the resource URL: %v", err)
+		return "", fmt.Errorf("failed to parse the resource URL: %v", err)
+	}
+	return fmt.Sprintf("%s://%s/", resourceURL.Scheme, resourceURL.Host), nil
+}
+
+// needsTokenSource returns true when the probes are authorized with the tokens of the token source rather than with
+// keys, passwords, signatures or the tokens of other identities
+func needsTokenSource() bool {
+	return len(apimKeys) == 0 && apiKey == "" && basicUser == "" && hmacKey == "" && !tokenStdin &&
+		authMode != authSigV4 && authMode != authNone && authMode != authNTLM && authMode != authNegotiate &&
+		identitiesFile == ""
+}
+
+// newTokenSource creates the token source of the authentication mode configured by the flags
+func newTokenSource() (TokenSource, error) {
+	// an already acquired token bypasses the authentication entirely
+	if token := firstNonEmpty(staticToken, os.Getenv(tokenEnv)); token != "" || staticTokenFile != "" {
+		if staticTokenFile == "" && numTokens > 1 {
+			return nil, fmt.Errorf("a single token was given, it cannot be used for -num-tokens %d", numTokens)
+		}
+		return NewStaticTokenSource(token, staticTokenFile), nil
+	}
+	switch authMode {
+	case authAzure:
+		azureTokenSource, err := newAzureTokenSource()
+		if err != nil {
+			return nil, err
+		}
+		return azureTokenSource, nil
+	case authManagedIdentity:
+		audience, err := resourceAudience()
+		if err != nil {
+			return nil, err
+		}
+		return NewManagedIdentityTokenSource(audience, identityClientID), nil
+	case authWorkloadIdentity:
+		audience, err := resourceAudience()
+		if err != nil {
+			return nil, err
+		}
+		// the workload identity webhook of AKS injects the identity in the environment of the pod
+		tenant := firstNonEmpty(tenantID, os.Getenv("AZURE_TENANT_ID"))
+		client := firstNonEmpty(clientID, os.Getenv("AZURE_CLIENT_ID"))
+		tokenFile := firstNonEmpty(federatedTokenFile, os.Getenv("AZURE_FEDERATED_TOKEN_FILE"))
+		if authorityHost == "" && os.Getenv("AZURE_AUTHORITY_HOST") != "" {
+			err = selectCloud(cloudName, os.Getenv("AZURE_AUTHORITY_HOST"))
+			if err != nil {
+				return nil, err
+			}
+		}
+		workloadTokenSource, err := NewWorkloadIdentityTokenSource(tenant, client, audience, tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		workloadTokenSource.scopes = scopeList()
+		return workloadTokenSource, nil
+	case authAzureCLI:
+		audience, err := resourceAudience()
+		if err != nil {
+			return nil, err
+		}
+		return NewAzureCLITokenSource(audience, tenantID), nil
+	case authChain:
+		audience, err := resourceAudience()
+		if err != nil {
+			return nil, err
+		}
+		return newDefaultChain(audience), nil
+	case authROPC:
+		azureTokenSource, err := newAzureTokenSource()
+		if err != nil {
+			return nil, err
+		}
+		// the password grant is only used when explicitly selected, it bypasses MFA and conditional access
+		azureTokenSource.clientSecret, azureTokenSource.certificate = "", nil
+		azureTokenSource.username = username
+		azureTokenSource.password = firstNonEmpty(password, os.Getenv(passwordEnv))
+		if azureTokenSource.username == "" || azureTokenSource.password == "" {
+			return nil, fmt.Errorf("the username and password are required by the %s authentication", authROPC)
+		}
+		return azureTokenSource, nil
+	case authBrowser:
+		if authorizeURL == "" {
+			// MSAL completes the same flow for Azure AD
+			azureTokenSource, err := newAzureTokenSource()
+			if err != nil {
+				return nil, err
+			}
+			azureTokenSource.interactive = true
+			return azureTokenSource, nil
+		}
+		browserTokenSource, err := NewBrowserTokenSource(authorizeURL, tokenURL, clientID, scopeList())
+		if err != nil {
+			return nil, err
+		}
+		return browserTokenSource, nil
+	case authOBO:
+		// the client ID and the credentials are the ones of the middle-tier service
+		middleTier, err := newAzureTokenSource()
+		if err != nil {
+			return nil, err
+		}
+		oboTokenSource, err := NewOnBehalfOfTokenSource(middleTier, firstNonEmpty(userAssertion, os.Getenv(userAssertionEnv)))
+		if err != nil {
+			return nil, err
+		}
+		return oboTokenSource, nil
+	case authGCP:
+		gcpTokenSource, err := NewGCPTokenSource(gcpAudience, scopeList())
+		if err != nil {
+			return nil, err
+		}
+		return gcpTokenSource, nil
+	case authSession:
+		sessionTokenSource, err := NewSessionTokenSource(loginURL, firstNonEmpty(loginData, os.Getenv(loginDataEnv)), resource)
+		if err != nil {
+			return nil, err
+		}
+		return sessionTokenSource, nil
+	case authExec:
+		audience, err := resourceAudience()
+		if err != nil {
+			return nil, err
+		}
+		execTokenSource, err := NewExecTokenSource(execCommand, audience)
+		if err != nil {
+			return nil, err
+		}
+		return execTokenSource, nil
+	case authOAuth2:
+		secret, err := resolveClientSecret()
+		if err != nil {
+			return nil, err
+		}
+		if clientKey != "" {
+			assertion, err := newClientAssertion(clientID, clientKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the client key: %v", err)
+			}
+			return NewGenericOAuth2AssertionTokenSource(tokenURL, clientID, assertion.withKeyID(clientKeyID), scopeList())
+		}
+		oauth2TokenSource, err := NewGenericOAuth2TokenSource(tokenURL, clientID, secret, scopeList())
+		if err != nil {
+			return nil, err
+		}
+		return oauth2TokenSource, nil
+	default:
+		return newCustomTokenSource(authMode, authSettings)
+	}
+}
+
+// scopeList returns the scopes of the flag
+func scopeList() []string {
+	if scopes == "" {
+		return nil
+	}
+	return strings.Split(scopes, ",")
+}
+
+// firstNonEmpty returns the first of the values which is not empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newAzureTokenSource creates the token source for the resource configured by the flags
+func newAzureTokenSource() (*AzureTokenSource, error) {
+	audience, err := resourceAudience()
+	if err != nil {
+		return nil, err
 	}
 
-	authority := fmt.Sprintf("%s//%s/", resourceURL.Scheme, resourceURL.Host)
+	azureTokenSource, err := NewAzureTokenSource(tenantID, clientID, audience)
+	if err != nil {
+		return nil, err
+	}
+	azureTokenSource.interactive = interactive
+	azureTokenSource.scopes = scopeList()
+	azureTokenSource.deviceCodeJSON = deviceCodeJSON
+	azureTokenSource.deviceCodeTimeout = deviceCodeTimeout
+	if tokenCachePath != "" {
+		azureTokenSource.cache = newTokenCache(tokenCachePath)
+	}
+	azureTokenSource.clientSecret, err = resolveClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	if clientKey != "" {
+		assertion, err := newClientAssertion(clientID, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client key: %v", err)
+		}
+		azureTokenSource.assertion, err = assertion.withThumbprint(clientKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if clientCert != "" {
+		password := certPassword
+		if password == "" {
+			password = os.Getenv(certPasswordEnv)
+		}
+		azureTokenSource.certificate, azureTokenSource.privateKey, err = loadClientCertificate(clientCert, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+	}
+	return azureTokenSource, nil
+}
+
+// resolveClientSecret returns the client secret of the flag or the environment, fetched from Key Vault when it is
+// a Key Vault reference
+func resolveClientSecret() (string, error) {
+	secret := clientSecret
+	if secret == "" {
+		// the environment keeps the secret out of the process list of CI agents
+		secret = os.Getenv(clientSecretEnv)
+	}
+	secret, err := resolveSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the client secret: %v", err)
+	}
+	return secret, nil
+}
+
+func main() {
+	parseFlags()
+	if logFile != "" {
+		output, err := openLog(logFile)
+		if err != nil {
+			log.Fatalf("failed to open the log file: %v", err)
+		}
+		defer output.Close()
+		log.SetOutput(output)
+	}
+
+	if len(command) > 0 {
+		runCommand(command)
+		return
+	}
+	if flag.NArg() > 0 {
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+
+	// -dry-run is the plan command of the flags already validated for the measurement
+	if dryRun {
+		runPlanCommand(nil)
+		return
+	}
+
+	_, err := url.ParseRequestURI(resource)
+	if err != nil {
+		log.Fatalf("failed to parse the resource URL: %v", err)
+	}
 
-	azureTokenSource, err := NewAzureTokenSource(tenantID, clientID, authority)
+	safety, err := loadSafetyConfig(safetyConfigPath)
 	if err != nil {
-		log.Fatalf("failed to create the token source: %v", err)
+		log.Fatalf("failed to load the safety config: %v", err)
+	}
+	targetURLs := []string{resource}
+	if secondaryHost != "" {
+		secondary, err := failoverTarget(probeTarget{URL: resource}, secondaryHost)
+		if err != nil {
+			log.Fatalf("failed to parse the secondary host: %v", err)
+		}
+		targetURLs = append(targetURLs, secondary.URL)
+	}
+	for _, hook := range []string{setupHook, teardownHook} {
+		if hook == "" || strings.HasPrefix(hook, execHookPrefix) {
+			continue
+		}
+		_, hookURL, err := parseHook(hook)
+		if err != nil {
+			log.Fatal(err)
+		}
+		targetURLs = append(targetURLs, hookURL)
+	}
+	err = checkTargetSafety(safety, targetURLs...)
+	if err != nil {
+		if !safetyOverride {
+			log.Fatalf("%v, use -i-know-what-i-am-doing to measure it anyway", err)
+		}
+		log.Printf("Warning: %v", err)
+	}
+	if !safetyOverride {
+		redirectSafety = safety
+	}
+
+	var tokenSource TokenSource
+	if apiKey == "" {
+		apiKey = os.Getenv(apiKeyEnv)
+	}
+	if useNetrc {
+		resourceURL, err := url.Parse(resource)
+		if err != nil {
+			log.Fatalf("failed to parse the resource URL: %v", err)
+		}
+		basicUser, basicPassword, err = netrcLogin(netrcPath(), resourceURL.Hostname())
+		if err != nil {
+			log.Fatalf("failed to look up the netrc login: %v", err)
+		}
+	}
+	if basicUser != "" && basicPassword == "" {
+		basicPassword = os.Getenv(basicPasswordEnv)
+	}
+	if hmacKey == "" {
+		hmacKey = os.Getenv(hmacKeyEnv)
+	}
+	if needsTokenSource() {
+		tokenSource, err = newTokenSource()
+		if err != nil {
+			log.Fatalf("failed to create the token source: %v", err)
+		}
+	}
+
+	if sampleLogPath != "" {
+		output, err := openLog(sampleLogPath)
+		if err != nil {
+			log.Fatalf("failed to open the sample log: %v", err)
+		}
+		defer output.Close()
+		samplesLog = newSampleLog(output)
 	}
 
-	tokens, err := fetchTokens(azureTokenSource, numTokens)
+	var pool *tokenPool
+	switch {
+	case len(apimKeys) > 0:
+		authorizeRequest = subscriptionKeyAuthorization
+		pool = newStaticTokenPool(apimKeys.keys())
+	case apiKey != "":
+		authorizeRequest = apiKeyAuthorization(apiKeyHeader)
+		pool = newStaticTokenPool([]string{apiKey})
+	case basicUser != "":
+		authorizeRequest = basicAuthorization(basicUser)
+		pool = newStaticTokenPool([]string{basicPassword})
+	case hmacKey != "":
+		signer, err := newHMACSigner(hmacKey, hmacKeyID, hmacHeader, hmacFormat, hmacDateHeader)
+		if err != nil {
+			log.Fatalf("failed to create the HMAC signer: %v", err)
+		}
+		authorizeRequest = signerAuthorization(signer)
+		pool = newStaticTokenPool([]string{firstNonEmpty(hmacKeyID, "hmac")})
+	case authMode == authSigV4:
+		signer, err := newSigV4Signer(resource, awsService, awsRegion)
+		if err != nil {
+			log.Fatalf("failed to create the SigV4 signer: %v", err)
+		}
+		// the identity of the measurement is the access key of the AWS credentials
+		accessKeyID, err := signer.accessKeyID()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Signing the requests for %s in %s with the access key %s", signer.service, signer.region, accessKeyID)
+		authorizeRequest = signerAuthorization(signer)
+		pool = newStaticTokenPool([]string{accessKeyID})
+	case authMode == authNone:
+		log.Printf("Sending the requests without any credential")
+		authorizeRequest = anonymousAuthorization
+		pool = newStaticTokenPool([]string{"anonymous"})
+	case authMode == authNTLM:
+		ntlmPassword := firstNonEmpty(password, os.Getenv(passwordEnv))
+		if username == "" || ntlmPassword == "" {
+			log.Fatalf("the username and password are required by the %s authentication", authNTLM)
+		}
+		authorizeRequest = basicAuthorization(username)
+		pool = newStaticTokenPool([]string{ntlmPassword})
+	case authMode == authNegotiate:
+		signer, principal, err := newKerberosSigner(servicePrincipalName)
+		if err != nil {
+			log.Fatalf("failed to create the Kerberos signer: %v", err)
+		}
+		log.Printf("Authenticating the requests with the Kerberos tickets of %s", principal)
+		authorizeRequest = signerAuthorization(signer)
+		pool = newStaticTokenPool([]string{principal})
+	case authMode == authSession:
+		authorizeRequest = cookieAuthorization
+		pool = newTokenPool(tokenSource, numTokens)
+	case tokenStdin:
+		stdinTokens, err := readTokens(os.Stdin)
+		if err != nil {
+			log.Fatalf("failed to read the tokens from stdin: %v", err)
+		}
+		log.Printf("Read %d tokens from stdin", len(stdinTokens))
+		pool = newStaticTokenPool(stdinTokens)
+	case identitiesFile != "":
+		pool, err = newIdentityPool(identitiesFile)
+		if err != nil {
+			log.Fatalf("failed to load the identities: %v", err)
+		}
+		log.Printf("Loaded %d identities from %s", pool.size, identitiesFile)
+	case roles != "":
+		roleTokens, err := acquireRoleTokens(strings.Split(roles, ","))
+		if err != nil {
+			log.Fatalf("failed to acquire the role tokens: %v", err)
+		}
+		pool = newStaticTokenPool(roleTokens)
+	default:
+		pool = newTokenPool(tokenSource, numTokens)
+	}
+	// the other tokens are acquired lazily when their measurement starts
+	firstToken, err := pool.get(0)
 	if err != nil {
-		log.Fatalf("failed to acquire %d tokens: %v", numTokens, err)
+		log.Fatalf("failed to acquire a token: %v", err)
+	}
+	warnHardCap(hardCapRate, pool.size)
+	if tokenAssignmentStrategy != assignDedicated {
+		log.Printf("Sharing %d tokens between the parallel workers of a single measurement (%s)", pool.size, tokenAssignmentStrategy)
+		if profile := profiles[0]; profile.name == "" {
+			profile.name = fmt.Sprintf("%d-tokens-%s", pool.size, tokenAssignmentStrategy)
+			profiles[0] = profile
+		}
 	}
 
+	audit = newAuditRecord()
+	// the audit record is also written when the run fails
+	defer runCleanups()
+	addCleanup(func() {
+		telemetry := pool.telemetry()
+		reportTokens(telemetry)
+		audit.addTokens(telemetry)
+		err := audit.write(auditLog)
+		if err != nil {
+			log.Printf("failed to write the audit record: %v", err)
+		}
+	})
+
 	// register the interrupt handler
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
+	handleLoadSignals(loadStep)
+
+	if preCommand != "" {
+		log.Printf("Running the pre command")
+		err = execHook(preCommand, runEnv(audit))
+		if err != nil {
+			fatalf("failed to run the pre command: %v", err)
+		}
+	}
+	addCleanup(func() { runPostCommand(postCommand, audit) })
+
+	client := newProbeClient(!fullHandshakes && !disableKeepAlive, !fullHandshakes)
+	hookAuth, err := hookCredential(safety, firstToken, setupHook, teardownHook)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if setupHook != "" {
+		log.Printf("Running the setup hook")
+		err = runHook(setupHook, client, hookAuth)
+		if err != nil {
+			fatalf("failed to run the setup hook: %v", err)
+		}
+	}
+	// the teardown also runs when the measurement fails
+	addCleanup(func() { runTeardown(teardownHook, client, hookAuth) })
+
+	if openAPISpec != "" {
+		crawlOpenAPI(openAPISpec, client, firstToken, interrupt)
+		return
+	}
+	if len(apimKeys) > 0 {
+		compareTiers(resourceTarget(client, probeMethod), "product", apimKeys.products(), pool, interrupt)
+		return
+	}
+	if roles != "" {
+		compareTiers(resourceTarget(client, probeMethod), "role", strings.Split(roles, ","), pool, interrupt)
+		return
+	}
+
+	if replayFile != "" {
+		replay, err = loadRequestMix(replayFile)
+		if err != nil {
+			fatalf("failed to load the captured requests: %v", err)
+		}
+		log.Printf("Replaying %d distinct captured requests", len(replay.requests))
+	}
+	if len(ranges) > 0 {
+		compareRanges(tokenSource, pool, probeTarget{client: client, method: http.MethodGet, URL: resource}, ranges, interrupt)
+		return
+	}
+
+	methods := []string{probeMethod}
+	if !isSafeMethod(probeMethod) {
+		log.Printf("Warning: the %s probes may create, modify or delete data of the resource", probeMethod)
+	}
+	if discover || sweepMethods {
+		allowed, err := discoverMethods(probeTarget{client: client, method: http.MethodOptions, URL: resource}, firstToken)
+		if err != nil {
+			fatalf("failed to discover the supported methods: %v", err)
+		}
+		log.Printf("Methods supported by the resource: %s", strings.Join(allowed, ", "))
+		if sweepMethods {
+			methods = safeMethods(allowed)
+			if len(methods) == 0 {
+				fatalf("the resource does not support any safe method to sweep")
+			}
+		}
+	}
+
+	// last are the results of the previous measurement, whose rate limit must reset before the next one
+	var last []measurement
+	for _, method := range methods {
+		if !waitForReset(last, interrupt) {
+			return
+		}
+		target := resourceTarget(client, method)
+		results, completed := runMeasurements(tokenSource, pool, target, interrupt)
+		if !completed {
+			return
+		}
+		last = results
+		if compareConditional {
+			if !waitForReset(last, interrupt) {
+				return
+			}
+			conditional, err := conditionalTarget(target, firstToken)
+			if err != nil {
+				log.Printf("Skipping the conditional requests: %v", err)
+			} else {
+				log.Printf("Measuring again with conditional requests")
+				conditionalResults, completed := runMeasurements(tokenSource, pool, conditional, interrupt)
+				if !completed {
+					return
+				}
+				compareConditionalRequests(results, conditionalResults)
+				last = conditionalResults
+			}
+		}
+		if !compareKeepAlive {
+			continue
+		}
+
+		if !waitForReset(last, interrupt) {
+			return
+		}
+		log.Printf("Measuring again with a new connection per request")
+		target.client = newProbeClient(false, !fullHandshakes)
+		freshResults, completed := runMeasurements(tokenSource, pool, target, interrupt)
+		if !completed {
+			return
+		}
+		compareConnectionReuse(results, freshResults)
+		last = freshResults
+	}
+}
+
+// compareConnectionReuse logs the limits measured with and without connection reuse side by side
+func compareConnectionReuse(keepAlive []measurement, fresh []measurement) {
+	for i := range keepAlive {
+		log.Printf("Rate limit of %s: %4.2f request/sec with keep-alive (throttled: %t), %4.2f request/sec with fresh connections (throttled: %t)",
+			profiles.profile(i).name, keepAlive[i].rate(), keepAlive[i].throttled, fresh[i].rate(), fresh[i].throttled)
+	}
+}
+
+// runMeasurements measures the rate limit of the target for every token, it returns false when interrupted
+func runMeasurements(tokenSource TokenSource, pool *tokenPool, target probeTarget, interrupt chan os.Signal) ([]measurement, bool) {
+	log.Printf("Measuring the rate limit of %s %s", target.method, target.URL)
 
 	abort := make(chan struct{})
+	// the shared tokens are all measured together by the same workers
+	measurements := pool.size
+	if tokenAssignmentStrategy != assignDedicated {
+		measurements = 1
+	}
+	barrier := newStartBarrier(measurements)
+	results := make([]measurement, measurements)
 	var wg sync.WaitGroup
-	for _, token := range tokens {
+	for i := 0; i < measurements; i++ {
 		wg.Add(1)
-		go func(URL string, token string) {
-			measureRatelimit(URL, token, parallelRequests, abort)
+		go func(i int, profile tokenProfile) {
+			tokens, err := assignTokens(pool, i, tokenAssignmentStrategy)
+			if err != nil {
+				log.Printf("failed to acquire the token of %s: %v", profile.name, err)
+				barrier.wait()
+				wg.Done()
+				return
+			}
+			results[i] = measureAssigned(target, tokens, profile, barrier, abort)
+			token := tokens.first()
+			audit.add(profile.name, target, results[i])
+			if results[i].throttled && secondaryHost != "" {
+				measureFailover(target, token, profile, results[i], abort)
+			}
+			// the keys and the piped tokens cannot be renewed, there is no token source to rotate
+			if results[i].throttled && rotationTokens > 0 && tokenSource != nil {
+				testTokenRotation(tokenSource, target, rotationTokens, abort)
+			}
+			if results[i].throttled && metadataTest {
+				testMetadataQuota(target, token, abort)
+			}
 			wg.Done()
-		}(resource, token)
+		}(i, profiles.profile(i))
 	}
+	barrier.open()
+	log.Printf("Started %d measurements at %s", measurements, barrier.start.Format(time.RFC3339Nano))
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	// wait until the program is interrupted
-	<-interrupt
+	// wait until the measurements complete or the program is interrupted
+	select {
+	case <-done:
+		return results, true
+	case <-interrupt:
+	}
 
 	log.Println("Waiting for rate limit probes to complete...")
 
 	close(abort)
 
 	// wait for all requests to complete
-	wg.Wait()
+	<-done
+	return results, false
 }
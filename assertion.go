@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	// clientAssertionType is the type of the JWT assertion authenticating a client instead of a secret
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// clientAssertionLifetime is the validity of a signed client assertion
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+// clientAssertion signs the self-issued JWTs which authenticate a client with its private key
+type clientAssertion struct {
+	clientID string
+	key      *rsa.PrivateKey
+	// header identifies the key to the authorization server, with kid or the x5t thumbprint for Azure AD
+	header map[string]string
+}
+
+// newClientAssertion loads the RSA private key of the client from an unencrypted PEM file
+func newClientAssertion(clientID string, keyFile string) (*clientAssertion, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil || strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+	if block == nil {
+		return nil, errors.New("no private key in the PEM file")
+	}
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = parsed.(*rsa.PrivateKey); !ok {
+				return nil, errors.New("the client private key is not an RSA key")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported %s, the private key must be unencrypted", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key: %v", err)
+	}
+	return &clientAssertion{
+		clientID: clientID,
+		key:      key,
+		header:   map[string]string{"alg": "RS256", "typ": "JWT"},
+	}, nil
+}
+
+// withKeyID identifies the key with its key ID
+func (ca *clientAssertion) withKeyID(keyID string) *clientAssertion {
+	if keyID != "" {
+		ca.header["kid"] = keyID
+	}
+	return ca
+}
+
+// withThumbprint identifies the key with the hex SHA-1 thumbprint of its certificate registered in Azure AD
+func (ca *clientAssertion) withThumbprint(thumbprint string) (*clientAssertion, error) {
+	digest, err := hex.DecodeString(strings.ReplaceAll(thumbprint, ":", ""))
+	if err != nil || len(digest) != 20 {
+		return nil, fmt.Errorf("invalid certificate thumbprint %q, expected 40 hex digits", thumbprint)
+	}
+	ca.header["x5t"] = base64.RawURLEncoding.EncodeToString(digest)
+	return ca, nil
+}
+
+// sign returns a new assertion for the token endpoint
+func (ca *clientAssertion) sign(audience string) (string, error) {
+	jti, err := randomURLSafe(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	header, err := json.Marshal(ca.header)
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": ca.clientID,
+		"sub": ca.clientID,
+		"aud": audience,
+		"jti": jti,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ca.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenRenewalLead is how long before their expiry the tokens are renewed during a measurement
+const tokenRenewalLead = 5 * time.Minute
+
+// token assignment strategies selected with -token-assignment
+const (
+	// assignDedicated measures every token separately with its own parallel workers
+	assignDedicated = "dedicated"
+	// assignRoundRobin shares the workers, each probe uses the next token in turn
+	assignRoundRobin = "round-robin"
+	// assignSticky shares the workers, each worker always uses the same token
+	assignSticky = "sticky"
+	// assignRandom shares the workers, each probe uses a token picked at random
+	assignRandom = "random"
+)
+
+var assignmentStrategies = []string{assignDedicated, assignRoundRobin, assignSticky, assignRandom}
+
+// tokenAssignment maps the tokens to the workers of a measurement
+type tokenAssignment struct {
+	strategy string
+	lock     sync.RWMutex
+	tokens   []string
+	next     uint64
+	// pool renews the tokens with the given indexes before they expire, nil when they cannot be renewed
+	pool    *tokenPool
+	indexes []int
+}
+
+// newTokenAssignment assigns the tokens to the workers with the given strategy
+func newTokenAssignment(strategy string, tokens []string) *tokenAssignment {
+	return &tokenAssignment{
+		strategy: strategy,
+		tokens:   tokens,
+	}
+}
+
+// token returns the token of the next probe sent by the worker
+func (ta *tokenAssignment) token(worker int) string {
+	ta.lock.RLock()
+	defer ta.lock.RUnlock()
+	switch ta.strategy {
+	case assignRoundRobin:
+		return ta.tokens[(atomic.AddUint64(&ta.next, 1)-1)%uint64(len(ta.tokens))]
+	case assignSticky:
+		return ta.tokens[worker%len(ta.tokens)]
+	case assignRandom:
+		return ta.tokens[rand.Intn(len(ta.tokens))]
+	default:
+		return ta.tokens[0]
+	}
+}
+
+// expiry returns the earliest expiry of the tokens, which bounds the measurement
+func (ta *tokenAssignment) expiry() (time.Time, bool) {
+	ta.lock.RLock()
+	defer ta.lock.RUnlock()
+	var earliest time.Time
+	for _, token := range ta.tokens {
+		if expiry, ok := tokenExpiry(token); ok && (earliest.IsZero() || expiry.Before(earliest)) {
+			earliest = expiry
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+// first returns the first token, the one diagnosing the authentication failures
+func (ta *tokenAssignment) first() string {
+	ta.lock.RLock()
+	defer ta.lock.RUnlock()
+	return ta.tokens[0]
+}
+
+// renewable returns true when the tokens can be renewed by their pool
+func (ta *tokenAssignment) renewable() bool {
+	return ta.pool != nil && (ta.pool.source != nil || ta.pool.sources != nil)
+}
+
+// renew refreshes the tokens shortly before they expire until stopped, the workers pick up the new tokens with
+// their next probe. When the tokens cannot be renewed, expired is closed once they expire
+func (ta *tokenAssignment) renew(name string, events *timeline, stop <-chan struct{}, expired chan<- struct{}) {
+	for {
+		expiry, ok := ta.expiry()
+		if !ok {
+			return
+		}
+		// the short-lived tokens are renewed halfway through their lifetime
+		wait := time.Until(expiry) - tokenRenewalLead
+		if half := time.Until(expiry) / 2; wait < half {
+			wait = half
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		for i, index := range ta.indexes {
+			token, err := ta.pool.renew(index)
+			if err != nil {
+				log.Printf("failed to renew the token of %s: %v", name, err)
+				events.add("token renewal failed")
+				ta.expire(expiry, stop, expired)
+				return
+			}
+			ta.lock.Lock()
+			ta.tokens[i] = token
+			ta.lock.Unlock()
+		}
+		renewed, ok := ta.expiry()
+		if !ok || !renewed.After(expiry) {
+			log.Printf("The token source of %s did not issue tokens with a later expiry, stopping the renewal", name)
+			events.add("token renewal stopped")
+			ta.expire(expiry, stop, expired)
+			return
+		}
+		log.Printf("Renewed the tokens of %s, valid until %s", name, renewed.Format(time.RFC3339))
+		events.add("tokens renewed")
+	}
+}
+
+// expire closes expired at the expiry of the tokens which could not be renewed, unless stopped before
+func (ta *tokenAssignment) expire(expiry time.Time, stop <-chan struct{}, expired chan<- struct{}) {
+	timer := time.NewTimer(time.Until(expiry))
+	defer timer.Stop()
+	select {
+	case <-stop:
+	case <-timer.C:
+		close(expired)
+	}
+}
+
+// assignTokens returns the tokens of the measurement with the given index, its own token when dedicated, otherwise
+// all the tokens of the pool shared by the same workers, which models clients spreading their requests over several
+// identities
+func assignTokens(pool *tokenPool, index int, strategy string) (*tokenAssignment, error) {
+	if strategy == assignDedicated {
+		token, err := pool.get(index)
+		if err != nil {
+			return nil, err
+		}
+		assignment := newTokenAssignment(strategy, []string{token})
+		assignment.pool, assignment.indexes = pool, []int{index}
+		return assignment, nil
+	}
+	var tokens []string
+	var indexes []int
+	for i := 0; i < pool.size; i++ {
+		token, err := pool.get(i)
+		if err != nil {
+			return nil, fmt.Errorf("token %d: %v", i, err)
+		}
+		tokens = append(tokens, token)
+		indexes = append(indexes, i)
+	}
+	assignment := newTokenAssignment(strategy, tokens)
+	assignment.pool, assignment.indexes = pool, indexes
+	return assignment, nil
+}
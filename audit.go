@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditResult summarizes the measurement of a token
+type auditResult struct {
+	Name      string  `json:"name"`
+	Method    string  `json:"method"`
+	URL       string  `json:"url"`
+	Accepted  uint64  `json:"accepted"`
+	Rate      float64 `json:"rate"`
+	Throttled bool    `json:"throttled"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+	// RetryAfter is the recovery time advertised when throttled
+	RetryAfter float64 `json:"retry_after_seconds,omitempty"`
+}
+
+// auditRecord accounts for the load generated by a run
+type auditRecord struct {
+	lock       sync.Mutex
+	Timestamp  time.Time     `json:"timestamp"`
+	Operator   string        `json:"operator"`
+	Target     string        `json:"target"`
+	Tokens     int           `json:"tokens"`
+	Parallel   int           `json:"parallel"`
+	Profiles   string        `json:"profiles,omitempty"`
+	ConfigHash string        `json:"config_hash"`
+	Results    []auditResult `json:"results"`
+	// Identities is the telemetry of the tokens
+	Identities []tokenTelemetry `json:"identities,omitempty"`
+}
+
+// defaultAuditLogPath returns the location of the audit log in the home directory of the user
+func defaultAuditLogPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".arl", "audit.log")
+}
+
+// operator returns who is running the measurement, ARL_OPERATOR takes precedence over the OS user
+func operator() string {
+	if name := os.Getenv("ARL_OPERATOR"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// secretFlags hold credentials, they are left out of the config hash which would let anyone confirm a guessed
+// secret offline
+var secretFlags = []string{
+	"api-key", "apim-key", "auth-config", "basic-pass", "cert-password", "client-secret", "hmac-key", "login-data",
+	"password", "proxy", "token", "user-assertion",
+}
+
+// configHash returns a digest of the flag values defining the measurement, without the credentials
+func configHash() string {
+	hash := sha256.New()
+	flag.VisitAll(func(f *flag.Flag) {
+		if contains(secretFlags, f.Name) {
+			return
+		}
+		fmt.Fprintf(hash, "%s=%s\n", f.Name, f.Value.String())
+	})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// auditURL returns the URL without its user info and with the values of the credential query parameters and of the
+// -param parameters redacted, the audit log may be sent to a remote endpoint
+func auditURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return strings.SplitN(rawURL, "?", 2)[0]
+	}
+	query := u.Query()
+	for _, param := range params {
+		if query.Has(param.key) {
+			query.Set(param.key, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, anonymizedURI(u, redactCredentials))
+}
+
+func newAuditRecord() *auditRecord {
+	return &auditRecord{
+		Timestamp:  time.Now().UTC(),
+		Operator:   operator(),
+		Target:     auditURL(resource),
+		Tokens:     numTokens,
+		Parallel:   parallelRequests,
+		Profiles:   profiles.String(),
+		ConfigHash: configHash(),
+	}
+}
+
+// add records the result of a measurement
+func (ar *auditRecord) add(name string, target probeTarget, result measurement) {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	ar.Results = append(ar.Results, auditResult{
+		Name:      name,
+		Method:    target.method,
+		URL:       auditURL(target.URL),
+		Accepted:  result.accepted,
+		Rate:      result.rate(),
+		Throttled: result.throttled,
+
+		Elapsed:    result.elapsed.Seconds(),
+		RetryAfter: result.retryAfter.Seconds(),
+	})
+}
+
+// addTokens records the telemetry of the tokens
+func (ar *auditRecord) addTokens(telemetry []tokenTelemetry) {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+	ar.Identities = telemetry
+}
+
+// write appends the record as a JSON line to a local file or posts it to an HTTP endpoint
+func (ar *auditRecord) write(destination string) error {
+	if destination == "" {
+		return nil
+	}
+	ar.lock.Lock()
+	data, err := json.Marshal(ar)
+	ar.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://") {
+		resp, err := http.Post(destination, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("audit log endpoint responded with %s", resp.Status)
+		}
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(destination), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(destination, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
@@ -1,91 +1,204 @@
 package main
 
 import (
-	"fmt"
-	"net/http"
-
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
 	"errors"
-	"github.com/ccojocar/adal"
+	"fmt"
+	"log"
 	"sync"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+	"github.com/ccojocar/arl/tokensource"
 )
 
-const authority = "https://login.microsoftonline.com/"
+// clientSecretEnv is the environment variable holding the client secret when the flag is not set
+const clientSecretEnv = "ARL_CLIENT_SECRET"
+
+// passwordEnv is the environment variable holding the password of the test user when the flag is not set
+const passwordEnv = "ARL_PASSWORD"
 
-//TokenSource interface which should be implemented by an access token provider
-type TokenSource interface {
-	Token() (string, error)
-	Refresh() (string, error)
+// certPasswordEnv is the environment variable holding the password of the PFX certificate when the flag is not set
+const certPasswordEnv = "ARL_CERT_PASSWORD"
+
+// deviceCodePayload is the device code information printed for automation
+type deviceCodePayload struct {
+	UserCode        *string `json:"user_code"`
+	VerificationURL *string `json:"verification_url"`
+	ExpiresIn       *int64  `json:"expires_in"`
+	Message         *string `json:"message"`
 }
 
+// TokenSource interface which should be implemented by an access token provider, see the tokensource package
+type TokenSource = tokensource.TokenSource
+
 // AzureTokenSource is the Azure access token provider
 type AzureTokenSource struct {
-	lock        sync.Mutex
-	oauthConfig adal.OAuthConfig
-	clientID    string
-	resource    string
-	spt         *adal.ServicePrincipalToken
+	lock     sync.Mutex
+	tenantID string
+	clientID string
+	resource string
+	// app is the MSAL client application, created on the first acquisition from the configured credentials
+	app msalClient
+	// username and password sign in a test user with the resource owner password credentials grant
+	username string
+	password string
+	// interactive signs in with the system browser instead of the device code flow
+	interactive bool
+	// deviceCodeJSON prints the device code payload as JSON for automation instead of a human message
+	deviceCodeJSON bool
+	// deviceCodeTimeout limits how long the device code flow waits for the user completion
+	deviceCodeTimeout time.Duration
+	// cache persists the access and refresh tokens across runs, nil disables the persistence
+	cache *tokenCache
+	// clientSecret switches to the client credentials grant of a service principal instead of the device code flow
+	clientSecret string
+	// certificate and privateKey authenticate the service principal with a certificate instead of a secret
+	certificate *x509.Certificate
+	privateKey  *rsa.PrivateKey
+	// assertion authenticates the service principal with a JWT signed by its private key, without the certificate
+	assertion *clientAssertion
+	// scopes are requested from the v2.0 endpoint instead of the static permissions of the resource
+	scopes []string
 }
 
 // NewAzureTokenSource create a new Azure token source
 func NewAzureTokenSource(tenantID string, clientID string, resource string) (*AzureTokenSource, error) {
-	oauthConfig, err := adal.NewOAuthConfig(authority, tenantID)
-	if err != nil {
-		return nil, err
-	}
 	return &AzureTokenSource{
-		oauthConfig: *oauthConfig,
-		clientID:    clientID,
-		resource:    resource,
-		spt:         nil,
+		tenantID: tenantID,
+		clientID: clientID,
+		resource: resource,
 	}, nil
 }
 
-// Token returns a new access token
+// requestScopes returns the scopes of the requested tokens, by default the static permissions of the resource
+func (ts *AzureTokenSource) requestScopes() []string {
+	if len(ts.scopes) > 0 {
+		return ts.scopes
+	}
+	return resourceScopes(ts.resource)
+}
+
+// Token returns the cached access token while it is valid, otherwise a new access token redeeming the cached
+// refresh token when available, the user signs in again when the refresh token cannot be redeemed
 func (ts *AzureTokenSource) Token() (string, error) {
 	ts.lock.Lock()
 	defer ts.lock.Unlock()
-	var err error
-	ts.spt, err = ts.acquireTokenDeviceCodeFlow()
-	return ts.spt.AccessToken, err
+	app, err := ts.application()
+	if err != nil {
+		return "", err
+	}
+	result, err := app.acquireSilent(context.Background(), ts.requestScopes(), false)
+	if err == nil {
+		return ts.acquired(result)
+	}
+	if err != errNoAccount && !ts.confidential() {
+		// the refresh token expired, was revoked or is subject to new conditional access policies
+		log.Printf("Failed to redeem the cached refresh token, signing in again: %v", err)
+	}
+	return ts.login()
 }
 
-// Refresh refreshes an existing and returns its new value
-func (ts *AzureTokenSource) Refresh() (string, error) {
+// Login acquires a new access token with the device code flow, the browser, or the client secret or certificate of
+// the service principal, regardless of the cached refresh token
+func (ts *AzureTokenSource) Login() (string, error) {
 	ts.lock.Lock()
 	defer ts.lock.Unlock()
-	if ts.spt == nil {
-		return "", errors.New("service principal token is nil. call Token() before Refresh()")
+	return ts.login()
+}
+
+func (ts *AzureTokenSource) login() (string, error) {
+	app, err := ts.application()
+	if err != nil {
+		return "", err
+	}
+	result, err := app.acquire(context.Background(), ts.requestScopes())
+	if err != nil {
+		return "", err
 	}
-	err := ts.spt.Refresh()
-	return ts.spt.AccessToken, err
+	return ts.acquired(result)
 }
 
-func (ts *AzureTokenSource) acquireTokenDeviceCodeFlow() (*adal.ServicePrincipalToken, error) {
-	callback := func(token adal.Token) error {
-		return nil
+// Refresh refreshes an existing and returns its new value. Unlike Token, it never falls back to an interactive sign
+// in, which would block the renewals of an unattended measurement, possibly while holding the token pool
+func (ts *AzureTokenSource) Refresh() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.app == nil {
+		return "", errors.New("the MSAL client is not created. call Token() before Refresh()")
+	}
+	result, err := ts.app.acquireSilent(context.Background(), ts.requestScopes(), true)
+	if err != nil && !ts.confidential() {
+		return "", fmt.Errorf("failed to redeem the refresh token, sign in again with 'arl auth login': %v", err)
 	}
-	oauthClient := &http.Client{}
-	deviceCode, err := adal.InitiateDeviceAuth(
-		oauthClient,
-		ts.oauthConfig,
-		ts.clientID,
-		ts.resource)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to start device auth flow: %s", err)
+		return "", err
 	}
+	return ts.acquired(result)
+}
 
-	fmt.Println(*deviceCode.Message)
+// confidential returns true when the token source authenticates as a service principal with its own credentials
+func (ts *AzureTokenSource) confidential() bool {
+	return ts.clientSecret != "" || ts.certificate != nil || ts.assertion != nil
+}
 
-	token, err := adal.WaitForUserCompletion(oauthClient, deviceCode)
+// application returns the MSAL client, a confidential client for the service principals and a public client for
+// the users
+func (ts *AzureTokenSource) application() (msalClient, error) {
+	if ts.app != nil {
+		return ts.app, nil
+	}
+	if ts.confidential() {
+		credential, err := ts.credential()
+		if err != nil {
+			return nil, err
+		}
+		// the service principal has no refresh token worth persisting
+		client, err := newConfidentialClient(ts.tenantID, ts.clientID, credential)
+		if err != nil {
+			return nil, err
+		}
+		ts.app = client
+		return ts.app, nil
+	}
+	client, err := newPublicClient(ts.tenantID, ts.clientID, ts.cache)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to finish device auth flow: %s", err)
+		return nil, err
 	}
+	client.username = ts.username
+	client.password = ts.password
+	client.interactive = ts.interactive
+	client.deviceCodeJSON = ts.deviceCodeJSON
+	client.deviceCodeTimeout = ts.deviceCodeTimeout
+	ts.app = client
+	return ts.app, nil
+}
 
-	spt, err := adal.NewServicePrincipalTokenFromManualToken(
-		ts.oauthConfig,
-		ts.clientID,
-		resource,
-		*token,
-		callback)
-	return spt, err
+// credential returns the credential of the service principal: its signed assertion, certificate or secret
+func (ts *AzureTokenSource) credential() (confidential.Credential, error) {
+	switch {
+	case ts.assertion != nil:
+		return confidential.NewCredFromAssertionCallback(func(ctx context.Context, options confidential.AssertionRequestOptions) (string, error) {
+			return ts.assertion.sign(options.TokenEndpoint)
+		}), nil
+	case ts.certificate != nil:
+		return confidential.NewCredFromCert([]*x509.Certificate{ts.certificate}, ts.privateKey)
+	default:
+		return confidential.NewCredFromSecret(ts.clientSecret)
+	}
+}
+
+// acquired records the resource and the expiry of the token in the cache for 'arl auth status'
+func (ts *AzureTokenSource) acquired(result public.AuthResult) (string, error) {
+	if ts.cache == nil || ts.confidential() {
+		return result.AccessToken, nil
+	}
+	err := ts.cache.describe(ts.tenantID, ts.clientID, ts.resource, result.ExpiresOn)
+	if err != nil {
+		return "", fmt.Errorf("failed to store the token in the cache: %v", err)
+	}
+	return result.AccessToken, nil
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authentication and authorization failure causes
+const (
+	causeExpired           = "expired token"
+	causeWrongTenant       = "wrong tenant"
+	causeWrongAudience     = "wrong audience"
+	causeConditionalAccess = "conditional access"
+	causeMissingRole       = "missing role or scope"
+	causeInvalidToken      = "invalid token"
+	causeForbidden         = "forbidden"
+)
+
+// authRemediation is the guidance printed for every failure cause
+var authRemediation = map[string]string{
+	causeExpired:           "the token expired, shorten -duration below the token lifetime or run 'arl auth login' for a fresh token",
+	causeWrongTenant:       "the token was issued by another tenant, set -tenant-id to the tenant of the API",
+	causeWrongAudience:     "the token was issued for %s, make the scheme and host of -resource match the App ID URI of the API",
+	causeConditionalAccess: "a conditional access policy blocks the token, satisfy it (MFA, compliant device, location) or exclude the measuring identity",
+	causeMissingRole:       "the identity lacks the permission, assign it a role (RBAC) or an app role/scope granting the operation",
+	causeInvalidToken:      "the API rejected the token, check the WWW-Authenticate challenge and the token issuer",
+	causeForbidden:         "the API refused the identity, the operation may be genuinely forbidden for it",
+}
+
+// authCauseMarkers are the error codes and phrases of the AAD and ARM error payloads identifying a cause,
+// checked in order since the payloads of some causes mention the others
+var authCauseMarkers = []struct {
+	cause   string
+	markers []string
+}{
+	{causeExpired, []string{"expiredauthenticationtoken", "token is expired", "lifetime validation failed", "idx10223"}},
+	{causeWrongTenant, []string{"invalidauthenticationtokentenant", "wrong issuer", "idx10205"}},
+	{causeWrongAudience, []string{"invalidauthenticationtokenaudience", "invalid audience", "audience validation failed", "idx10214"}},
+	{causeConditionalAccess, []string{"insufficient_claims", "aadsts53", "conditional access", "claims="}},
+	{causeMissingRole, []string{"authorizationfailed", "insufficient_scope", "does not have authorization", "missing role", "permission"}},
+}
+
+var errorDescriptionPattern = regexp.MustCompile(`error_description="([^"]*)"`)
+
+// isAuthFailure returns true for the statuses of rejected credentials or permissions
+func isAuthFailure(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// diagnoseAuthFailure classifies the cause of a 401 or 403 response from its challenge and error payload
+func diagnoseAuthFailure(statusCode int, challenge string, body []byte, token string) string {
+	text := strings.ToLower(challenge + " " + string(body))
+	for _, c := range authCauseMarkers {
+		for _, marker := range c.markers {
+			if strings.Contains(text, marker) {
+				return c.cause
+			}
+		}
+	}
+	if expiry, ok := tokenExpiry(token); ok && time.Now().After(expiry) {
+		return causeExpired
+	}
+	if statusCode == http.StatusUnauthorized {
+		return causeInvalidToken
+	}
+	return causeForbidden
+}
+
+// authErrorDetail extracts a human readable error message from the challenge or the error payload
+func authErrorDetail(challenge string, body []byte) string {
+	if match := errorDescriptionPattern.FindStringSubmatch(challenge); match != nil {
+		return match[1]
+	}
+	var payload struct {
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		if payload.ErrorDescription != "" {
+			return payload.ErrorDescription
+		}
+		var armError struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(payload.Error, &armError) == nil && armError.Message != "" {
+			return fmt.Sprintf("%s: %s", armError.Code, armError.Message)
+		}
+	}
+	if challenge != "" {
+		return challenge
+	}
+	detail := strings.TrimSpace(string(body))
+	if len(detail) > 200 {
+		detail = detail[:200] + "..."
+	}
+	return detail
+}
+
+type authFailureCause struct {
+	count  int
+	status int
+	detail string
+}
+
+// authFailures accounts the 401 and 403 responses per diagnosed cause
+type authFailures struct {
+	lock     sync.Mutex
+	statuses map[int]int
+	causes   map[string]*authFailureCause
+}
+
+func newAuthFailures() *authFailures {
+	return &authFailures{
+		statuses: make(map[int]int),
+		causes:   make(map[string]*authFailureCause),
+	}
+}
+
+// record diagnoses a rejected response and returns the number of responses with its status
+func (af *authFailures) record(resp *probeResponse, token string) int {
+	challenge := resp.Header.Get("WWW-Authenticate")
+	cause := diagnoseAuthFailure(resp.StatusCode, challenge, resp.errorBody, token)
+
+	af.lock.Lock()
+	defer af.lock.Unlock()
+	c, ok := af.causes[cause]
+	if !ok {
+		c = &authFailureCause{status: resp.StatusCode, detail: authErrorDetail(challenge, resp.errorBody)}
+		af.causes[cause] = c
+	}
+	c.count++
+	af.statuses[resp.StatusCode]++
+	return af.statuses[resp.StatusCode]
+}
+
+// report logs the diagnosed causes together with their remediation
+func (af *authFailures) report(name string, token string) {
+	af.lock.Lock()
+	defer af.lock.Unlock()
+	var causes []string
+	for cause := range af.causes {
+		causes = append(causes, cause)
+	}
+	sort.Strings(causes)
+	for _, cause := range causes {
+		c := af.causes[cause]
+		remediation := authRemediation[cause]
+		if cause == causeWrongAudience {
+			audience := "another resource"
+			if claims, ok := parseTokenClaims(token); ok && claims.Aud != "" {
+				audience = string(claims.Aud)
+			}
+			remediation = fmt.Sprintf(remediation, audience)
+		}
+		log.Printf("Auth failures of %s: %d %d %s responses due to %s (%s)", name, c.count, c.status,
+			http.StatusText(c.status), cause, c.detail)
+		log.Printf("Remediation for %s: %s", cause, remediation)
+	}
+}
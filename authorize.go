@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// authorizeRequest attaches the credential of a probe to its request, the bearer token by default
+var authorizeRequest = bearerAuthorization
+
+// bearerAuthorization sends the credential as an OAuth bearer token
+func bearerAuthorization(req *http.Request, token string) error {
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+// requestSigner signs the outgoing probe requests instead of attaching a token
+type requestSigner interface {
+	sign(req *http.Request) error
+}
+
+// signerAuthorization signs the request, the credential of the probe only identifies the signer in the reports
+func signerAuthorization(signer requestSigner) func(req *http.Request, identity string) error {
+	return func(req *http.Request, identity string) error {
+		return signer.sign(req)
+	}
+}
+
+// cookieAuthorization sends the credential as the session cookies of a login, unless the client sends them from the
+// jar of the session
+func cookieAuthorization(req *http.Request, cookies string) error {
+	if _, ok := sessionJars.Load(cookies); ok {
+		return nil
+	}
+	req.Header.Set("Cookie", cookies)
+	return nil
+}
+
+// anonymousAuthorization sends the request without any credential, to measure the limits applied per client IP
+func anonymousAuthorization(req *http.Request, identity string) error {
+	return nil
+}
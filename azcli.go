@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// AzureCLITokenSource acquires the tokens of the account signed in with the Azure CLI
+type AzureCLITokenSource struct {
+	resource string
+	tenantID string
+}
+
+// NewAzureCLITokenSource creates a token source for the Azure CLI account, an empty tenant selects its default
+func NewAzureCLITokenSource(resource string, tenantID string) *AzureCLITokenSource {
+	return &AzureCLITokenSource{
+		resource: resource,
+		tenantID: tenantID,
+	}
+}
+
+// Token returns an access token of the Azure CLI account
+func (ts *AzureCLITokenSource) Token() (string, error) {
+	args := []string{"account", "get-access-token", "--resource", ts.resource, "--output", "json"}
+	if ts.tenantID != "" {
+		args = append(args, "--tenant", ts.tenantID)
+	}
+	cmd := exec.Command("az", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("az account get-access-token failed, run 'az login': %s", message)
+		}
+		return "", fmt.Errorf("failed to run az account get-access-token: %v", err)
+	}
+	var token struct {
+		AccessToken string `json:"accessToken"`
+	}
+	err = json.Unmarshal(output, &token)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the Azure CLI token: %v", err)
+	}
+	return token.AccessToken, nil
+}
+
+// Refresh returns the current access token of the Azure CLI account, which renews it only close to its expiry
+func (ts *AzureCLITokenSource) Refresh() (string, error) {
+	return ts.Token()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// headerSize returns the size of the header lines on the wire
+func headerSize(header http.Header) int64 {
+	var size int64
+	for name, values := range header {
+		for _, value := range values {
+			// name: value\r\n
+			size += int64(len(name) + len(value) + 4)
+		}
+	}
+	return size
+}
+
+// requestSize estimates the number of bytes of a request on the wire
+func requestSize(req *http.Request) int64 {
+	// METHOD URI HTTP/1.1\r\nHost: host\r\n ... \r\n
+	size := int64(len(req.Method)+len(req.URL.RequestURI())+len(" HTTP/1.1\r\n")) +
+		int64(len("Host: \r\n")+len(req.URL.Host)) + headerSize(req.Header) + 2
+	if req.ContentLength > 0 {
+		size += req.ContentLength
+	}
+	return size
+}
+
+// responseHeaderSize estimates the number of bytes of the status line and headers of a response on the wire
+func responseHeaderSize(resp *http.Response) int64 {
+	// HTTP/1.1 200 OK\r\n ... \r\n
+	return int64(len(resp.Proto)+len(resp.Status)+3) + headerSize(resp.Header) + 2
+}
+
+// reportBandwidth logs the bytes exchanged by the probes and the resulting throughput
+func reportBandwidth(name string, totals sampleTotals, elapsed time.Duration) {
+	if totals.count == 0 || elapsed <= 0 {
+		return
+	}
+	sent, received, count := totals.requestBytes, totals.responseBytes, totals.count
+	log.Printf("Bytes of %s: %d sent (%d avg), %d received (%d avg), %4.3f MB/s",
+		name, sent, sent/count, received, received/count, float64(sent+received)/1e6/elapsed.Seconds())
+}
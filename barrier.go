@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// startBarrier releases all the measurements in the same instant and provides them a shared start time
+type startBarrier struct {
+	ready   sync.WaitGroup
+	release chan struct{}
+	start   time.Time
+}
+
+func newStartBarrier(parties int) *startBarrier {
+	b := &startBarrier{release: make(chan struct{})}
+	b.ready.Add(parties)
+	return b
+}
+
+// wait blocks a party until the barrier is opened and returns the shared start time
+func (b *startBarrier) wait() time.Time {
+	b.ready.Done()
+	<-b.release
+	return b.start
+}
+
+// open waits for all parties to be ready and releases them
+func (b *startBarrier) open() {
+	b.ready.Wait()
+	b.start = time.Now()
+	close(b.release)
+}
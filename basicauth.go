@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// basicPasswordEnv is the environment variable holding the basic authentication password when the flag is not set
+const basicPasswordEnv = "ARL_BASIC_PASS"
+
+// basicAuthorization sends the credential as the password of the user with the HTTP basic authentication
+func basicAuthorization(user string) func(req *http.Request, password string) error {
+	return func(req *http.Request, password string) error {
+		req.SetBasicAuth(user, password)
+		return nil
+	}
+}
+
+// netrcPath returns the location of the netrc file, $NETRC or ~/.netrc
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcEntry is the login of a machine in a netrc file, the machine of the default entry is empty
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// netrcLogin looks up the login and password of the host in a netrc file, falling back to its default entry
+func netrcLogin(path string, host string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var entries []*netrcEntry
+	var entry *netrcEntry
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine", "default":
+			entry = &netrcEntry{}
+			if fields[i] == "machine" && i+1 < len(fields) {
+				i++
+				entry.machine = fields[i]
+			}
+			entries = append(entries, entry)
+		case "login", "password", "account":
+			if i+1 >= len(fields) || entry == nil {
+				continue
+			}
+			i++
+			if fields[i-1] == "login" {
+				entry.login = fields[i]
+			} else if fields[i-1] == "password" {
+				entry.password = fields[i]
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.machine == host {
+			return entry.login, entry.password, nil
+		}
+	}
+	for _, entry := range entries {
+		if entry.machine == "" {
+			return entry.login, entry.password, nil
+		}
+	}
+	return "", "", fmt.Errorf("no entry for %s in %s", host, path)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/browser"
+)
+
+// browserLoginTimeout limits how long the authorization code flow waits for the user to sign in
+const browserLoginTimeout = 5 * time.Minute
+
+// BrowserTokenSource signs in the user with the authorization code flow and PKCE, receiving the code on a localhost
+// redirect listener, for the OAuth2 authorization servers outside of Azure
+type BrowserTokenSource struct {
+	lock         sync.Mutex
+	authorizeURL string
+	tokenURL     string
+	clientID     string
+	scopes       []string
+	refreshToken string
+}
+
+// NewBrowserTokenSource creates a token source signing in the user of a public OAuth2 client
+func NewBrowserTokenSource(authorizeURL string, tokenURL string, clientID string, scopes []string) (*BrowserTokenSource, error) {
+	if authorizeURL == "" || tokenURL == "" || clientID == "" {
+		return nil, fmt.Errorf("the authorize URL, the token URL and the client ID are required by the %s authentication", authBrowser)
+	}
+	return &BrowserTokenSource{
+		authorizeURL: authorizeURL,
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		scopes:       scopes,
+	}, nil
+}
+
+// Token redeems the refresh token of the previous sign in, otherwise signs in the user with the browser
+func (ts *BrowserTokenSource) Token() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.refreshToken != "" {
+		token, err := ts.redeem()
+		if err == nil {
+			return token, nil
+		}
+		log.Printf("Failed to redeem the refresh token, signing in again: %v", err)
+	}
+	return ts.login()
+}
+
+// Refresh redeems the refresh token for a new access token, without opening the browser in the middle of a
+// measurement when it cannot be redeemed
+func (ts *BrowserTokenSource) Refresh() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.refreshToken == "" {
+		return "", errors.New("no refresh token to redeem")
+	}
+	token, err := ts.redeem()
+	if err != nil {
+		return "", fmt.Errorf("failed to redeem the refresh token: %v", err)
+	}
+	return token, nil
+}
+
+// redeem exchanges the refresh token for a new access token
+func (ts *BrowserTokenSource) redeem() (string, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {ts.clientID},
+		"refresh_token": {ts.refreshToken},
+	}
+	token, err := requestToken(ts.tokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	return ts.acquired(token), nil
+}
+
+func (ts *BrowserTokenSource) acquired(token *tokenResponse) string {
+	if token.RefreshToken != "" {
+		ts.refreshToken = token.RefreshToken
+	}
+	return token.AccessToken
+}
+
+// login opens the browser on the authorization endpoint and exchanges the code sent to the redirect listener
+func (ts *BrowserTokenSource) login() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start the redirect listener: %v", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/", listener.Addr().(*net.TCPAddr).Port)
+
+	verifier, err := randomURLSafe(32)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomURLSafe(16)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authorizeURL, err := url.Parse(ts.authorizeURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the authorize URL: %v", err)
+	}
+	query := authorizeURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", ts.clientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("state", state)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	if len(ts.scopes) > 0 {
+		query.Set("scope", strings.Join(ts.scopes, " "))
+	}
+	authorizeURL.RawQuery = query.Encode()
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		switch {
+		case params.Get("state") != state:
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		case params.Get("error") != "":
+			fmt.Fprintln(w, "Sign in failed, you can close this window.")
+			select {
+			case failures <- fmt.Errorf("%s: %s", params.Get("error"), params.Get("error_description")):
+			default:
+			}
+		default:
+			fmt.Fprintln(w, "Signed in, you can close this window.")
+			select {
+			case codes <- params.Get("code"):
+			default:
+			}
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Printf("Sign in with the browser, or open %s\n", authorizeURL)
+	err = browser.OpenURL(authorizeURL.String())
+	if err != nil {
+		log.Printf("Failed to open the browser: %v", err)
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err = <-failures:
+		return "", fmt.Errorf("failed to sign in with the browser: %v", err)
+	case <-time.After(browserLoginTimeout):
+		return "", fmt.Errorf("browser sign in not completed within %v", browserLoginTimeout)
+	}
+
+	token, err := requestToken(ts.tokenURL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {ts.clientID},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to redeem the authorization code: %v", err)
+	}
+	return ts.acquired(token), nil
+}
+
+// randomURLSafe returns a random URL safe string of n bytes of entropy
+func randomURLSafe(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// rangeTolerance is the relative spread of the accepted requests (or bytes) still considered as constant
+const rangeTolerance = 0.2
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// parseByteSize parses a size such as 512, 64KiB or 16MB
+func parseByteSize(value string) (int64, error) {
+	multiplier := int64(1)
+	number := value
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			number = strings.TrimSuffix(value, unit.suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// rangeSizes are the chunk sizes of the ranged GETs, e.g. 64KiB,1MiB,16MiB
+type rangeSizes []int64
+
+func (rs *rangeSizes) String() string {
+	var sizes []string
+	for _, size := range *rs {
+		sizes = append(sizes, strconv.FormatInt(size, 10))
+	}
+	return strings.Join(sizes, ",")
+}
+
+func (rs *rangeSizes) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		size, err := parseByteSize(part)
+		if err != nil {
+			return err
+		}
+		*rs = append(*rs, size)
+	}
+	return nil
+}
+
+// spread returns the relative difference between the lowest and the highest value
+func spread(values []float64) float64 {
+	lowest, highest := math.Inf(1), 0.0
+	for _, value := range values {
+		lowest = math.Min(lowest, value)
+		highest = math.Max(highest, value)
+	}
+	if lowest <= 0 {
+		return math.Inf(1)
+	}
+	return highest/lowest - 1
+}
+
+// compareRanges measures the target with ranged GETs of every chunk size and reports whether the rate limit
+// applies per request, per range or per byte
+func compareRanges(tokenSource TokenSource, pool *tokenPool, target probeTarget, sizes []int64, interrupt chan os.Signal) {
+	target.method = http.MethodGet
+	results := make([][]measurement, len(sizes))
+	for i, size := range sizes {
+		if i > 0 && !waitForReset(results[i-1], interrupt) {
+			return
+		}
+		log.Printf("Measuring with ranges of %d bytes", size)
+		// a range of a compressed representation cannot be decompressed on its own
+		target.header = http.Header{
+			"Range":           []string{fmt.Sprintf("bytes=0-%d", size-1)},
+			"Accept-Encoding": []string{"identity"},
+		}
+		var completed bool
+		results[i], completed = runMeasurements(tokenSource, pool, target, interrupt)
+		if !completed {
+			return
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "RANGE (bytes)\tACCEPTED\tACCEPTED BYTES\tRATE (req/s)\tTHROTTLED")
+	var requests, bytes []float64
+	throttled := true
+	for i, size := range sizes {
+		var accepted uint64
+		var rate float64
+		sizeThrottled := true
+		for _, result := range results[i] {
+			accepted += result.accepted
+			rate += result.rate()
+			sizeThrottled = sizeThrottled && result.throttled
+		}
+		throttled = throttled && sizeThrottled
+		requests = append(requests, float64(accepted))
+		bytes = append(bytes, float64(accepted)*float64(size))
+		fmt.Fprintf(w, "%d\t%d\t%d\t%4.2f\t%t\n", size, accepted, accepted*uint64(size), rate, sizeThrottled)
+	}
+	w.Flush()
+
+	switch {
+	case !throttled:
+		log.Println("Not every range size reached the rate limit, the limit unit is inconclusive")
+	case len(sizes) < 2:
+		log.Println("Measure at least two range sizes to find out the limit unit")
+	case spread(requests) <= rangeTolerance:
+		log.Println("The rate limit applies per request, regardless of the range size")
+	case spread(bytes) <= rangeTolerance:
+		log.Println("The rate limit applies per byte, the accepted bytes are the same for every range size")
+	default:
+		log.Println("The rate limit applies per range unit, neither the accepted requests nor bytes are constant")
+	}
+}
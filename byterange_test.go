@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		size    int64
+		wantErr bool
+	}{
+		{value: "512", size: 512},
+		{value: "512B", size: 512},
+		{value: "64KiB", size: 64 << 10},
+		{value: "64K", size: 64 << 10},
+		{value: "64KB", size: 64000},
+		{value: "16MiB", size: 16 << 20},
+		{value: "16MB", size: 16000000},
+		{value: "1GiB", size: 1 << 30},
+		{value: "1 MiB", size: 1 << 20},
+		{value: "", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "-1KiB", wantErr: true},
+		{value: "MiB", wantErr: true},
+		{value: "1.5MiB", wantErr: true},
+		{value: "1TiB", wantErr: true},
+	}
+	for _, test := range tests {
+		size, err := parseByteSize(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, expected an error", test.value, size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseByteSize(%q) failed: %v", test.value, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("parseByteSize(%q) = %d, expected %d", test.value, size, test.size)
+		}
+	}
+}
+
+func TestSpread(t *testing.T) {
+	tests := []struct {
+		values []float64
+		spread float64
+	}{
+		{values: []float64{10}, spread: 0},
+		{values: []float64{10, 10, 10}, spread: 0},
+		{values: []float64{10, 12, 11}, spread: 0.2},
+		{values: []float64{5, 20}, spread: 3},
+		{values: []float64{0, 10}, spread: math.Inf(1)},
+	}
+	for _, test := range tests {
+		if spread := spread(test.values); spread != test.spread && math.Abs(spread-test.spread) > 1e-9 {
+			t.Errorf("spread(%v) = %f, expected %f", test.values, spread, test.spread)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// loadClientCertificate reads the certificate and the RSA private key of a service principal from a PFX (PKCS#12)
+// or an unencrypted PEM file, or from a Key Vault certificate referenced as keyvault://<vault>/<certificate>
+func loadClientCertificate(path string, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(path, keyVaultScheme) {
+		// the secret backing a Key Vault certificate is its base64 encoded PFX
+		var secret string
+		secret, err = resolveSecret(path)
+		if err == nil {
+			data, err = base64.StdEncoding.DecodeString(secret)
+		}
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if strings.Contains(string(data), "-----BEGIN") {
+		return decodePEMCertificate(data)
+	}
+	key, certificate, err := pkcs12.Decode(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode the PFX certificate: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("the certificate private key is not an RSA key")
+	}
+	return certificate, rsaKey, nil
+}
+
+// decodePEMCertificate returns the first certificate and RSA private key of the PEM blocks
+func decodePEMCertificate(data []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var certificate *x509.Certificate
+	var key *rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate != nil {
+				continue
+			}
+			parsed, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the certificate: %v", err)
+			}
+			certificate = parsed
+		case "RSA PRIVATE KEY":
+			parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the private key: %v", err)
+			}
+			key = parsed
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse the private key: %v", err)
+			}
+			rsaKey, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, nil, errors.New("the certificate private key is not an RSA key")
+			}
+			key = rsaKey
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, nil, errors.New("encrypted PEM private keys are not supported, use a password protected PFX file")
+		}
+	}
+	if certificate == nil || key == nil {
+		return nil, nil, errors.New("the PEM file must contain a certificate and its private key")
+	}
+	return certificate, key, nil
+}
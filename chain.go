@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imdsProbeTimeout bounds the check whether the instance metadata service is reachable, off Azure it never answers
+const imdsProbeTimeout = time.Second
+
+// chainLink is a token source of the chain, created only when its credentials look available
+type chainLink struct {
+	name string
+	// create returns nil without an error when the credentials of the link are not available
+	create func() (TokenSource, error)
+}
+
+// ChainedTokenSource tries the links in order and sticks to the first one acquiring a token
+type ChainedTokenSource struct {
+	lock     sync.Mutex
+	links    []chainLink
+	selected TokenSource
+}
+
+// NewChainedTokenSource creates a token source trying the links in order
+func NewChainedTokenSource(links ...chainLink) *ChainedTokenSource {
+	return &ChainedTokenSource{links: links}
+}
+
+// Token returns a token of the selected link, selecting the first link which acquires a token on the first call
+func (ts *ChainedTokenSource) Token() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.selected != nil {
+		return ts.selected.Token()
+	}
+	var failures []string
+	for _, link := range ts.links {
+		source, err := link.create()
+		if err == nil && source == nil {
+			continue
+		}
+		var token string
+		if err == nil {
+			token, err = source.Token()
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", link.name, err))
+			continue
+		}
+		log.Printf("Authenticated with the %s credentials", link.name)
+		ts.selected = source
+		return token, nil
+	}
+	if len(failures) == 0 {
+		return "", errors.New("no credentials available in the chain")
+	}
+	return "", fmt.Errorf("no credentials of the chain acquired a token: %s", strings.Join(failures, "; "))
+}
+
+// Refresh refreshes the token of the selected link
+func (ts *ChainedTokenSource) Refresh() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.selected == nil {
+		return "", errors.New("no credentials selected. call Token() before Refresh()")
+	}
+	return ts.selected.Refresh()
+}
+
+// imdsAvailable returns true when the instance metadata service is reachable
+func imdsAvailable() bool {
+	conn, err := net.DialTimeout("tcp", "169.254.169.254:80", imdsProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// newDefaultChain creates the chain of the environment credentials, the client secret or certificate, the managed
+// identity, the Azure CLI and finally the device code flow
+func newDefaultChain(audience string) *ChainedTokenSource {
+	return NewChainedTokenSource(
+		chainLink{"environment", func() (TokenSource, error) {
+			tenant, client := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+			if tenant == "" || client == "" {
+				return nil, nil
+			}
+			if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+				source, err := NewAzureTokenSource(tenant, client, audience)
+				if err != nil {
+					return nil, err
+				}
+				source.clientSecret = secret
+				source.scopes = scopeList()
+				return source, nil
+			}
+			if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" {
+				workloadTokenSource, err := NewWorkloadIdentityTokenSource(tenant, client, audience, tokenFile)
+				if err != nil {
+					return nil, err
+				}
+				workloadTokenSource.scopes = scopeList()
+				return workloadTokenSource, nil
+			}
+			return nil, nil
+		}},
+		chainLink{"service principal", func() (TokenSource, error) {
+			if clientSecret == "" && clientCert == "" && clientKey == "" && os.Getenv(clientSecretEnv) == "" {
+				return nil, nil
+			}
+			return newAzureTokenSource()
+		}},
+		// the managed identity and the Azure CLI only issue the tokens of the resource, they are skipped when
+		// -scopes are requested so that every link requests the same scopes
+		chainLink{"managed identity", func() (TokenSource, error) {
+			if len(scopeList()) > 0 || !imdsAvailable() {
+				return nil, nil
+			}
+			return NewManagedIdentityTokenSource(audience, identityClientID), nil
+		}},
+		chainLink{"Azure CLI", func() (TokenSource, error) {
+			if len(scopeList()) > 0 {
+				return nil, nil
+			}
+			if _, err := exec.LookPath("az"); err != nil {
+				return nil, nil
+			}
+			return NewAzureCLITokenSource(audience, tenantID), nil
+		}},
+		chainLink{"device code", func() (TokenSource, error) {
+			source, err := NewAzureTokenSource(tenantID, clientID, audience)
+			if err != nil {
+				return nil, err
+			}
+			source.interactive = interactive
+			source.scopes = scopeList()
+			source.deviceCodeJSON = deviceCodeJSON
+			source.deviceCodeTimeout = deviceCodeTimeout
+			if tokenCachePath != "" {
+				source.cache = newTokenCache(tokenCachePath)
+			}
+			return source, nil
+		}},
+	)
+}
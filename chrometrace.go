@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// traceEvent is an event of the Chrome trace event format, understood by chrome://tracing and Perfetto
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"`
+	Duration  int64                  `json:"dur,omitempty"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Scope     string                 `json:"s,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// chromeTrace accumulates the requests of all the measurements as a trace with a process per token and a
+// thread per concurrent request
+type chromeTrace struct {
+	lock   sync.Mutex
+	origin time.Time
+	events []traceEvent
+	pids   int
+}
+
+var requestTrace = &chromeTrace{}
+
+// microseconds returns the trace timestamp of the time
+func (ct *chromeTrace) microseconds(t time.Time) int64 {
+	return t.Sub(ct.origin).Nanoseconds() / 1000
+}
+
+// add appends the samples and the timeline events of a measurement to the trace
+func (ct *chromeTrace) add(name string, start time.Time, samples []sample, events []timelineEvent) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	if ct.origin.IsZero() {
+		ct.origin = start
+	}
+	ct.pids++
+	pid := ct.pids
+	ct.events = append(ct.events, traceEvent{
+		Name:  "process_name",
+		Phase: "M",
+		PID:   pid,
+		Args:  map[string]interface{}{"name": name},
+	})
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i].start.Before(samples[j].start) })
+	// assign every request to the first lane which is free by its start, the lanes show the concurrency
+	var lanes []time.Time
+	for _, s := range samples {
+		lane := 0
+		for lane < len(lanes) && lanes[lane].After(s.start) {
+			lane++
+		}
+		end := s.start.Add(s.latency)
+		if lane == len(lanes) {
+			lanes = append(lanes, end)
+		} else {
+			lanes[lane] = end
+		}
+		category := "accepted"
+		if s.status == http.StatusTooManyRequests || s.status == http.StatusServiceUnavailable {
+			category = "throttled"
+		} else if s.status >= http.StatusBadRequest {
+			category = "failed"
+		}
+		ct.events = append(ct.events, traceEvent{
+			Name:      fmt.Sprintf("%d", s.status),
+			Category:  category,
+			Phase:     "X",
+			Timestamp: ct.microseconds(s.start),
+			Duration:  s.latency.Nanoseconds()/1000 + 1,
+			PID:       pid,
+			TID:       lane + 1,
+			Args: map[string]interface{}{
+				"request_bytes":  s.requestBytes,
+				"response_bytes": s.responseBytes,
+			},
+		})
+	}
+
+	for _, event := range events {
+		ct.events = append(ct.events, traceEvent{
+			Name:      event.message,
+			Category:  "timeline",
+			Phase:     "i",
+			Timestamp: ct.microseconds(event.at),
+			PID:       pid,
+			Scope:     "p",
+		})
+	}
+}
+
+// write saves the trace accumulated so far as a JSON trace file
+func (ct *chromeTrace) write(path string) error {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+	data, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{ct.events, "ms"})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
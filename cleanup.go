@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// cleanups are run in reverse order when the run ends, also when it fails with fatalf
+var cleanups struct {
+	lock  sync.Mutex
+	funcs []func()
+}
+
+// addCleanup registers a function run at the end of the run, e.g. writing the audit record
+func addCleanup(cleanup func()) {
+	cleanups.lock.Lock()
+	defer cleanups.lock.Unlock()
+	cleanups.funcs = append(cleanups.funcs, cleanup)
+}
+
+// runCleanups runs the registered functions once, the last registered first
+func runCleanups() {
+	for {
+		cleanups.lock.Lock()
+		n := len(cleanups.funcs)
+		if n == 0 {
+			cleanups.lock.Unlock()
+			return
+		}
+		cleanup := cleanups.funcs[n-1]
+		cleanups.funcs = cleanups.funcs[:n-1]
+		cleanups.lock.Unlock()
+		cleanup()
+	}
+}
+
+// fatalf logs the error and runs the cleanups before exiting, unlike log.Fatalf which skips them
+func fatalf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	runCleanups()
+	os.Exit(1)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// azureCloud holds the endpoints which differ between the public and the sovereign Azure clouds
+type azureCloud struct {
+	authority        string
+	keyVaultDomain   string
+	keyVaultResource string
+}
+
+// clouds are the Azure clouds selectable with -cloud
+var clouds = map[string]azureCloud{
+	"public": {
+		authority:        "https://login.microsoftonline.com/",
+		keyVaultDomain:   "vault.azure.net",
+		keyVaultResource: "https://vault.azure.net",
+	},
+	"usgov": {
+		authority:        "https://login.microsoftonline.us/",
+		keyVaultDomain:   "vault.usgovcloudapi.net",
+		keyVaultResource: "https://vault.usgovcloudapi.net",
+	},
+	"china": {
+		authority:        "https://login.chinacloudapi.cn/",
+		keyVaultDomain:   "vault.azure.cn",
+		keyVaultResource: "https://vault.azure.cn",
+	},
+}
+
+// activeCloud is the cloud of the measured resource
+var activeCloud = clouds["public"]
+
+// cloudNames returns the names of the known clouds
+func cloudNames() string {
+	var names []string
+	for name := range clouds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// selectCloud activates the named cloud, a non empty authority overrides the authority of the cloud
+func selectCloud(name string, authority string) error {
+	cloud, ok := clouds[name]
+	if !ok {
+		return fmt.Errorf("unknown cloud %q, expected one of %s", name, cloudNames())
+	}
+	if authority != "" {
+		if !strings.HasSuffix(authority, "/") {
+			authority += "/"
+		}
+		cloud.authority = authority
+	}
+	activeCloud = cloud
+	return nil
+}
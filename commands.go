@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands are the subcommands of arl, with the number of words of their name
+var commands = map[string]int{
+	"auth":   2,
+	"plan":   1,
+	"export": 1,
+	"record": 1,
+}
+
+// command is the name of the subcommand being run, e.g. [auth login], empty for a measurement
+var command []string
+
+// commandName returns the name of the subcommand at the beginning of the arguments, the flags follow it
+func commandName(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	words, ok := commands[args[0]]
+	if !ok {
+		return nil
+	}
+	// a missing auth command is reported by the auth command itself
+	for i := 1; i < words; i++ {
+		if i >= len(args) || strings.HasPrefix(args[i], "-") {
+			return args[:i]
+		}
+	}
+	return args[:words]
+}
+
+// runCommand executes a subcommand such as 'auth login'
+func runCommand(args []string) {
+	switch args[0] {
+	case "auth":
+		runAuthCommand(args[1:])
+	case "plan":
+		runPlanCommand(args[1:])
+	case "export":
+		runExportCommand(args[1:])
+	case "record":
+		runRecordCommand(args[1:])
+	default:
+		log.Fatalf("unknown command %q", args[0])
+	}
+}
+
+// runPlanCommand prints the expected requests, duration, bytes and cost of the configured run
+func runPlanCommand(args []string) {
+	_, err := url.ParseRequestURI(resource)
+	if err != nil {
+		log.Fatalf("failed to parse the resource URL: %v", err)
+	}
+	err = printPlan()
+	if err != nil {
+		log.Fatalf("failed to plan the measurement: %v", err)
+	}
+}
+
+// runExportCommand prints the client configuration derived from a result file, by default the last run of the
+// audit log
+func runExportCommand(args []string) {
+	path := auditLog
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+	if path == "" {
+		log.Fatal("missing the results, the audit log is disabled")
+	}
+	err := exportResults(os.Stdout, path, exportFormat)
+	if err != nil {
+		log.Fatalf("failed to export the results of %s: %v", path, err)
+	}
+}
+
+// runRecordCommand proxies the client traffic to the upstream and captures the mix of requests for the replay
+func runRecordCommand(args []string) {
+	if upstream == "" {
+		log.Fatal("missing the upstream URL")
+	}
+	err := recordTraffic(listenAddr, upstream, recordSample, recordRedact, measureDuration, recordFile)
+	if err != nil {
+		log.Fatalf("failed to record the traffic: %v", err)
+	}
+}
+
+func runAuthCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing auth command, expected 'login' or 'status'")
+	}
+	if tokenCachePath == "" {
+		log.Fatal("the token cache is disabled")
+	}
+
+	switch args[0] {
+	case "login":
+		azureTokenSource, err := newAzureTokenSource()
+		if err != nil {
+			log.Fatalf("failed to create the token source: %v", err)
+		}
+		if azureTokenSource.confidential() {
+			log.Fatal("the client credentials grant does not need a login, there is no refresh token to store")
+		}
+		_, err = azureTokenSource.Login()
+		if err != nil {
+			log.Fatalf("failed to login: %v", err)
+		}
+		log.Printf("Refresh token stored in %s", tokenCachePath)
+	case "status":
+		entries, err := newTokenCache(tokenCachePath).entries()
+		if err != nil {
+			log.Fatalf("failed to read the token cache: %v", err)
+		}
+		if len(entries) == 0 {
+			log.Printf("No tokens cached in %s", tokenCachePath)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "TENANT\tCLIENT\tRESOURCE\tACCESS TOKEN EXPIRES\tREFRESH TOKEN")
+		for _, entry := range entries {
+			refresh := "no"
+			if len(entry.MSAL) > 0 {
+				refresh = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.TenantID, entry.ClientID, entry.Resource,
+				entry.ExpiresOn.Local().Format(time.RFC3339), refresh)
+		}
+		w.Flush()
+	default:
+		log.Fatalf("unknown auth command %q", args[0])
+	}
+}
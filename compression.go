@@ -0,0 +1,66 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptedEncodings are the content codings which may be requested with -accept-encoding
+var acceptedEncodings = []string{"identity", "gzip", "br"}
+
+// parseAcceptEncoding validates the comma separated content codings requested by the probes
+func parseAcceptEncoding(value string) (string, error) {
+	var codings []string
+	for _, coding := range strings.Split(value, ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if !contains(acceptedEncodings, coding) {
+			return "", fmt.Errorf("unsupported encoding %q, expected one of %s", coding, strings.Join(acceptedEncodings, ", "))
+		}
+		codings = append(codings, coding)
+	}
+	return strings.Join(codings, ", "), nil
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
+
+// decodeBody returns the decompressed body of a response, the probes always request their -accept-encoding
+// explicitly so that the transport does not decompress the body before it is counted
+func decodeBody(body io.Reader, contentEncoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip":
+		reader, err := gzip.NewReader(body)
+		if err == io.EOF {
+			// an empty body, e.g. of a HEAD request
+			return body, nil
+		}
+		return reader, err
+	case "br":
+		return brotli.NewReader(body), nil
+	}
+	return body, nil
+}
+
+// reportCompression logs the size of the response bodies on the wire and once decompressed
+func reportCompression(name string, totals sampleTotals) {
+	compressed, decompressed := totals.bodyBytes, totals.decompressedBytes
+	if compressed == 0 || compressed == decompressed {
+		return
+	}
+	log.Printf("Response bodies of %s: %d bytes on the wire, %d decompressed (ratio %.2f)",
+		name, compressed, decompressed, float64(decompressed)/float64(compressed))
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		value    string
+		encoding string
+		wantErr  bool
+	}{
+		{value: "gzip", encoding: "gzip"},
+		{value: "identity", encoding: "identity"},
+		{value: "br,gzip", encoding: "br, gzip"},
+		{value: " BR , Gzip ", encoding: "br, gzip"},
+		{value: "", wantErr: true},
+		{value: "deflate", wantErr: true},
+		{value: "gzip,", wantErr: true},
+		{value: "gzip;q=0.5", wantErr: true},
+	}
+	for _, test := range tests {
+		encoding, err := parseAcceptEncoding(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseAcceptEncoding(%q) = %q, expected an error", test.value, encoding)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAcceptEncoding(%q) failed: %v", test.value, err)
+			continue
+		}
+		if encoding != test.encoding {
+			t.Errorf("parseAcceptEncoding(%q) = %q, expected %q", test.value, encoding, test.encoding)
+		}
+	}
+}
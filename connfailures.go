@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// connection level failure categories
+const (
+	failureTLS     = "TLS handshake failure"
+	failureRefused = "connection refused"
+	failureReset   = "connection reset"
+	failureClosed  = "connection closed"
+	failureTimeout = "timeout"
+	// the timeouts of the phases of a request
+	failureDialTimeout      = "dial timeout"
+	failureHandshakeTimeout = "TLS handshake timeout"
+	failureHeaderTimeout    = "response header timeout"
+)
+
+// classifyConnFailure returns the category of a connection level failure, empty for other errors
+func classifyConnFailure(err error) string {
+	var recordHeaderErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return ""
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return failureReset
+	case errors.As(err, &recordHeaderErr), errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr),
+		strings.Contains(err.Error(), "tls: "):
+		return failureTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return timeoutPhase(err)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return failureClosed
+	}
+	return ""
+}
+
+// timeoutPhase returns the category of a timeout according to the phase of the request which timed out
+func timeoutPhase(err error) string {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return failureDialTimeout
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return failureHandshakeTimeout
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return failureHeaderTimeout
+	}
+	return failureTimeout
+}
+
+type connFailureCategory struct {
+	count int
+	first time.Time
+	last  time.Time
+}
+
+// connFailures accounts the connection level failures per category
+type connFailures struct {
+	lock       sync.Mutex
+	total      int
+	categories map[string]*connFailureCategory
+}
+
+func newConnFailures() *connFailures {
+	return &connFailures{categories: make(map[string]*connFailureCategory)}
+}
+
+// record registers a failure and returns the number of failures of its category and the overall number
+func (cf *connFailures) record(category string) (int, int) {
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+	now := time.Now()
+	c, ok := cf.categories[category]
+	if !ok {
+		c = &connFailureCategory{first: now}
+		cf.categories[category] = c
+	}
+	c.count++
+	c.last = now
+	cf.total++
+	return c.count, cf.total
+}
+
+// report logs the failures of each category with the time of their first and last occurrence
+func (cf *connFailures) report(name string, start time.Time) {
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+	var categories []string
+	for category := range cf.categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		c := cf.categories[category]
+		log.Printf("Connection failures of %s: %d %s, first at %+.3fs, last at %+.3fs",
+			name, c.count, category, c.first.Sub(start).Seconds(), c.last.Sub(start).Seconds())
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// remainingHeaders lists the response headers which may advertise the remaining request quota
+var remainingHeaders = []string{"RateLimit-Remaining", "X-RateLimit-Remaining"}
+
+// headerConsistency cross-checks the advertised remaining quota against the accepted requests
+type headerConsistency struct {
+	lock sync.Mutex
+	// tolerance is the amount of reordering which can be explained by parallel requests
+	tolerance int64
+	observed  bool
+	first     int64
+	last      int64
+	lowest    int64
+	accepted  int64
+	increases int
+	backends  map[string]int64
+}
+
+func newHeaderConsistency(tolerance int) *headerConsistency {
+	return &headerConsistency{
+		tolerance: int64(tolerance),
+		backends:  make(map[string]int64),
+	}
+}
+
+func parseRemaining(header http.Header) (int64, bool) {
+	for _, name := range remainingHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		remaining, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		return remaining, true
+	}
+	return 0, false
+}
+
+// record registers an accepted response and the quota it advertises
+func (hc *headerConsistency) record(resp *http.Response) {
+	remaining, ok := parseRemaining(resp.Header)
+
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if !hc.observed {
+		if !ok {
+			return
+		}
+		hc.observed = true
+		hc.first = remaining
+		hc.last = remaining
+		hc.lowest = remaining
+	}
+	hc.accepted++
+	if !ok {
+		return
+	}
+	if remaining > hc.last+hc.tolerance {
+		hc.increases++
+	}
+	if remaining < hc.lowest {
+		hc.lowest = remaining
+	}
+	hc.last = remaining
+	if backendHeader != "" {
+		hc.backends[resp.Header.Get(backendHeader)] = remaining
+	}
+}
+
+// report logs the inconsistencies detected between the advertised quota and the accepted requests
+func (hc *headerConsistency) report() {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if !hc.observed {
+		return
+	}
+
+	consistent := true
+	if hc.accepted > hc.tolerance && hc.lowest >= hc.first {
+		consistent = false
+		log.Printf("Rate limit header inconsistency: remaining quota never decreased after %d accepted requests", hc.accepted)
+	}
+	if hc.increases > 0 {
+		consistent = false
+		log.Printf("Rate limit header inconsistency: remaining quota jumped up %d times", hc.increases)
+	}
+	if hc.increases == 0 {
+		drift := hc.accepted - (hc.first - hc.last)
+		if drift > hc.tolerance || drift < -hc.tolerance {
+			consistent = false
+			log.Printf("Rate limit header inconsistency: %d accepted requests but advertised quota dropped by %d (drift %d)",
+				hc.accepted, hc.first-hc.last, drift)
+		}
+	}
+	if len(hc.backends) > 1 {
+		lowest, highest := hc.last, hc.last
+		for _, remaining := range hc.backends {
+			if remaining < lowest {
+				lowest = remaining
+			}
+			if remaining > highest {
+				highest = remaining
+			}
+		}
+		if highest-lowest > hc.tolerance {
+			consistent = false
+			log.Printf("Rate limit header inconsistency: %d backends disagree on remaining quota (%d..%d)",
+				len(hc.backends), lowest, highest)
+		}
+	}
+	if consistent {
+		log.Printf("Rate limit headers are consistent with %d accepted requests", hc.accepted)
+	}
+}
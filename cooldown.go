@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// waitForReset waits until the window of the rate limit which throttled the measurements resets before the limit is
+// measured again, for the longest Retry-After of the throttled measurements or else the -cooldown, it returns false
+// when interrupted
+func waitForReset(results []measurement, interrupt chan os.Signal) bool {
+	wait, throttled := resetDelay(results)
+	if !throttled {
+		return true
+	}
+	log.Printf("Waiting %s for the rate limit to reset", wait)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-interrupt:
+		return false
+	}
+}
+
+// resetDelay returns how long the rate limit takes to reset after the measurements, false when none was throttled
+func resetDelay(results []measurement) (time.Duration, bool) {
+	var wait time.Duration
+	throttled := false
+	for _, result := range results {
+		if !result.throttled {
+			continue
+		}
+		throttled = true
+		if result.retryAfter > wait {
+			wait = result.retryAfter
+		}
+	}
+	if throttled && wait == 0 {
+		wait = cooldown
+	}
+	return wait, throttled
+}
@@ -0,0 +1,16 @@
+package main
+
+import "log"
+
+// estimateCost returns the monetary cost of sending the given number of requests
+func estimateCost(requests uint64) float64 {
+	return float64(requests) * unitsPerRequest * price
+}
+
+// reportCost logs the estimated cost of the requests sent by a measurement
+func reportCost(requests uint64) {
+	if price == 0 {
+		return
+	}
+	log.Printf("Estimated cost of %d requests: %.4f", requests, estimateCost(requests))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// discoverMethods issues the OPTIONS request of the target and returns the methods listed by the Allow header
+func discoverMethods(target probeTarget, token string) ([]string, error) {
+	resp, err := send(context.Background(), target, token)
+	if err != nil {
+		return nil, err
+	}
+	allow := resp.Header.Get("Allow")
+	if allow == "" {
+		return nil, fmt.Errorf("no Allow header in the OPTIONS response (status %d)", resp.StatusCode)
+	}
+
+	var methods []string
+	for _, method := range strings.Split(allow, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods, nil
+}
+
+// isSafeMethod returns true when the method does not modify the resource
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// safeMethods filters the methods which do not modify the resource
+func safeMethods(methods []string) []string {
+	var safe []string
+	for _, method := range methods {
+		if isSafeMethod(method) {
+			safe = append(safe, method)
+		}
+	}
+	return safe
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainGrace is how long the cancelled probes are given to return before they are abandoned
+const drainGrace = time.Second
+
+// inFlightDrain accounts the probes which are still in flight when a measurement stops
+type inFlightDrain struct {
+	stopping  int32
+	inFlight  int64
+	completed int64
+	cancelled int64
+	discarded int64
+}
+
+func (d *inFlightDrain) stopped() bool {
+	return atomic.LoadInt32(&d.stopping) == 1
+}
+
+// begin registers a probe which is about to be sent, it returns false when the measurement already stopped
+func (d *inFlightDrain) begin() bool {
+	if d.stopped() {
+		atomic.AddInt64(&d.discarded, 1)
+		return false
+	}
+	atomic.AddInt64(&d.inFlight, 1)
+	return true
+}
+
+// end registers the outcome of a probe
+func (d *inFlightDrain) end(err error) {
+	atomic.AddInt64(&d.inFlight, -1)
+	if !d.stopped() {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		atomic.AddInt64(&d.cancelled, 1)
+	} else {
+		atomic.AddInt64(&d.completed, 1)
+	}
+}
+
+// wait stops the dispatching and waits for the in-flight probes, cancelling them once the timeout expires
+func (d *inFlightDrain) wait(wg *sync.WaitGroup, cancel context.CancelFunc, timeout time.Duration, name string) {
+	atomic.StoreInt32(&d.stopping, 1)
+	inFlight := atomic.LoadInt64(&d.inFlight)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(drainGrace):
+		}
+	}
+
+	discarded := atomic.LoadInt64(&d.discarded)
+	if inFlight == 0 && discarded == 0 {
+		return
+	}
+	log.Printf("In-flight probes of %s at shutdown: %d completed, %d cancelled, %d abandoned (%d queued probes discarded)",
+		name, atomic.LoadInt64(&d.completed), atomic.LoadInt64(&d.cancelled), atomic.LoadInt64(&d.inFlight), discarded)
+}
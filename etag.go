@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// conditionalTarget fetches the ETag of the target and returns the target of the conditional requests revalidating
+// it, which the service answers with a 304 while the resource is unchanged
+func conditionalTarget(target probeTarget, token string) (probeTarget, error) {
+	resp, err := send(context.Background(), target, token)
+	if err != nil {
+		return target, err
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return target, fmt.Errorf("no ETag in the response (status %d)", resp.StatusCode)
+	}
+	conditional := target
+	conditional.header = target.header.Clone()
+	if conditional.header == nil {
+		conditional.header = http.Header{}
+	}
+	conditional.header.Set("If-None-Match", etag)
+	// the 304 is the accepted answer of the conditional requests only
+	conditional.accepted = append(successCodes{"304"}, successStatuses...)
+	resp, err = send(context.Background(), conditional, token)
+	if err != nil {
+		return target, err
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return target, fmt.Errorf("the conditional request of the ETag %s got a %d instead of a 304", etag, resp.StatusCode)
+	}
+	return conditional, nil
+}
+
+// compareConditionalRequests logs the limits measured with full and with conditional responses side by side
+func compareConditionalRequests(full []measurement, conditional []measurement) {
+	for i := range full {
+		log.Printf("Rate limit of %s: %4.2f request/sec with full responses (throttled: %t), %4.2f request/sec with 304 responses (throttled: %t)",
+			profiles.profile(i).name, full[i].rate(), full[i].throttled, conditional[i].rate(), conditional[i].throttled)
+	}
+}
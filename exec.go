@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecTokenSource runs an external credential helper which prints a JSON document with the token and its expiry,
+// e.g. {"token": "...", "expiry": "2024-01-02T15:04:05Z"}, so that any authentication system can provide the tokens
+type ExecTokenSource struct {
+	lock     sync.Mutex
+	command  string
+	resource string
+	token    string
+	expiry   time.Time
+}
+
+// execCredential is the document printed by the credential helper, the expiry is optional
+type execCredential struct {
+	Token  string    `json:"token"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// NewExecTokenSource creates a token source running the shell command, the resource is passed to the command in
+// the ARL_RESOURCE environment variable
+func NewExecTokenSource(command string, resource string) (*ExecTokenSource, error) {
+	if command == "" {
+		return nil, fmt.Errorf("the -exec-cmd is required by the %s authentication", authExec)
+	}
+	return &ExecTokenSource{
+		command:  command,
+		resource: resource,
+	}, nil
+}
+
+// Token returns the token printed by the last run of the helper while it is valid, otherwise runs it again
+func (ts *ExecTokenSource) Token() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if ts.token != "" && (ts.expiry.IsZero() || time.Now().Add(tokenExpiryMargin).Before(ts.expiry)) {
+		return ts.token, nil
+	}
+	return ts.run()
+}
+
+// Refresh runs the helper again for a new token
+func (ts *ExecTokenSource) Refresh() (string, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.run()
+}
+
+func (ts *ExecTokenSource) run() (string, error) {
+	cmd := exec.Command("sh", "-c", ts.command)
+	cmd.Env = append(os.Environ(), "ARL_RESOURCE="+ts.resource)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("the credential helper failed: %s", message)
+		}
+		return "", fmt.Errorf("failed to run the credential helper: %v", err)
+	}
+	var credential execCredential
+	err = json.Unmarshal(output, &credential)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the output of the credential helper: %v", err)
+	}
+	if credential.Token == "" {
+		return "", errors.New("the credential helper printed no token")
+	}
+	ts.token = credential.Token
+	ts.expiry = credential.Expiry
+	return ts.token, nil
+}
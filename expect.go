@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// expectedSubstrings must all be contained in the successful response bodies
+type expectedSubstrings []string
+
+func (es *expectedSubstrings) String() string {
+	return strings.Join(*es, ",")
+}
+
+func (es *expectedSubstrings) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty expected body content")
+	}
+	*es = append(*es, value)
+	return nil
+}
+
+// jsonPathExpectation is a value expected at a JSONPath of the successful response bodies
+type jsonPathExpectation struct {
+	path  string
+	steps []interface{}
+	// value is compared to the scalar at the path, any non-null value matches when it is not set
+	value    string
+	hasValue bool
+}
+
+// expectedPaths must all match the successful response bodies
+type expectedPaths []jsonPathExpectation
+
+func (ep *expectedPaths) String() string {
+	var paths []string
+	for _, expectation := range *ep {
+		paths = append(paths, expectation.path)
+	}
+	return strings.Join(paths, ",")
+}
+
+func (ep *expectedPaths) Set(value string) error {
+	expectation := jsonPathExpectation{path: value}
+	if i := strings.Index(value, "="); i >= 0 {
+		expectation.path, expectation.value, expectation.hasValue = value[:i], value[i+1:], true
+	}
+	steps, err := parseJSONPath(expectation.path)
+	if err != nil {
+		return err
+	}
+	expectation.steps = steps
+	*ep = append(*ep, expectation)
+	return nil
+}
+
+// parseJSONPath parses the subset of JSONPath made of member names and array indexes, e.g. $.value[0].status,
+// into the names and indexes to walk
+func parseJSONPath(path string) ([]interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q, expected a path starting with $", path)
+	}
+	var steps []interface{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q, empty member name", path)
+			}
+			steps = append(steps, rest[1:end+1])
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q, unclosed index", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSONPath %q, expected a positive array index", path)
+			}
+			steps = append(steps, index)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q, expected . or [ at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// lookup returns the value at the path of the decoded JSON document
+func (jp jsonPathExpectation) lookup(document interface{}) (interface{}, bool) {
+	value := document
+	for _, step := range jp.steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+			value = array[step]
+		}
+	}
+	return value, true
+}
+
+// expectBodies returns true when the successful response bodies are checked
+func expectBodies() bool {
+	return len(expectContains) > 0 || len(expectJSONPaths) > 0
+}
+
+// unexpectedBody returns why a successful response body does not match the expectations, empty when it matches
+func unexpectedBody(body []byte) string {
+	for _, expected := range expectContains {
+		if !bytes.Contains(body, []byte(expected)) {
+			return fmt.Sprintf("body without %q", expected)
+		}
+	}
+	if len(expectJSONPaths) == 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Sprintf("body is not JSON: %v", err)
+	}
+	for _, expectation := range expectJSONPaths {
+		value, ok := expectation.lookup(document)
+		if !ok || value == nil {
+			return fmt.Sprintf("no value at %s", expectation.path)
+		}
+		if !expectation.hasValue {
+			continue
+		}
+		var actual string
+		switch value := value.(type) {
+		case string:
+			actual = value
+		case json.Number, bool:
+			actual = fmt.Sprint(value)
+		default:
+			return fmt.Sprintf("no scalar at %s", expectation.path)
+		}
+		if actual != expectation.value {
+			return fmt.Sprintf("%s is %q instead of %q", expectation.path, actual, expectation.value)
+		}
+	}
+	return ""
+}
+
+// unexpectedBodies counts the successful responses whose body does not match the expectations, e.g. the error
+// payloads of an API gateway answered with a 200
+type unexpectedBodies struct {
+	lock    sync.Mutex
+	count   int
+	reasons map[string]int
+}
+
+func newUnexpectedBodies() *unexpectedBodies {
+	return &unexpectedBodies{reasons: make(map[string]int)}
+}
+
+// record registers a response with an unexpected body and returns the number of such responses
+func (ub *unexpectedBodies) record(reason string) int {
+	ub.lock.Lock()
+	defer ub.lock.Unlock()
+	ub.count++
+	ub.reasons[reason]++
+	return ub.count
+}
+
+// report logs the responses which did not count as successful because of their body
+func (ub *unexpectedBodies) report(name string) {
+	ub.lock.Lock()
+	defer ub.lock.Unlock()
+	if ub.count == 0 {
+		return
+	}
+	var reasons []string
+	for reason, count := range ub.reasons {
+		reasons = append(reasons, fmt.Sprintf("%s (%d)", reason, count))
+	}
+	sort.Strings(reasons)
+	log.Printf("Unexpected bodies of %s: %d successful responses counted as failures: %s", name, ub.count,
+		strings.Join(reasons, ", "))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		steps   []interface{}
+		wantErr bool
+	}{
+		{path: "$", steps: nil},
+		{path: "$.status", steps: []interface{}{"status"}},
+		{path: "$.value[0].status", steps: []interface{}{"value", 0, "status"}},
+		{path: "$[2][10]", steps: []interface{}{2, 10}},
+		{path: "status", wantErr: true},
+		{path: "$..status", wantErr: true},
+		{path: "$.value[0", wantErr: true},
+		{path: "$.value[-1]", wantErr: true},
+		{path: "$.value[a]", wantErr: true},
+		{path: "$value", wantErr: true},
+	}
+	for _, test := range tests {
+		steps, err := parseJSONPath(test.path)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseJSONPath(%q) = %v, expected an error", test.path, steps)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) failed: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(steps, test.steps) {
+			t.Errorf("parseJSONPath(%q) = %#v, expected %#v", test.path, steps, test.steps)
+		}
+	}
+}
+
+func TestUnexpectedBody(t *testing.T) {
+	defer func(contains expectedSubstrings, paths expectedPaths) {
+		expectContains, expectJSONPaths = contains, paths
+	}(expectContains, expectJSONPaths)
+
+	tests := []struct {
+		name     string
+		contains []string
+		paths    []string
+		body     string
+		reason   string
+	}{
+		{name: "no expectation", body: "anything"},
+		{name: "contained", contains: []string{"ok"}, body: `{"status":"ok"}`},
+		{name: "not contained", contains: []string{"ok"}, body: `{"status":"failed"}`, reason: `body without "ok"`},
+		{name: "any value", paths: []string{"$.value[0].id"}, body: `{"value":[{"id":1}]}`},
+		{name: "null value", paths: []string{"$.id"}, body: `{"id":null}`, reason: "no value at $.id"},
+		{name: "missing index", paths: []string{"$.value[1]"}, body: `{"value":[1]}`, reason: "no value at $.value[1]"},
+		{name: "string value", paths: []string{"$.status=ok"}, body: `{"status":"ok"}`},
+		{name: "number value", paths: []string{"$.count=10"}, body: `{"count":10}`},
+		{name: "boolean value", paths: []string{"$.done=true"}, body: `{"done":true}`},
+		{name: "other value", paths: []string{"$.status=ok"}, body: `{"status":"failed"}`,
+			reason: `$.status is "failed" instead of "ok"`},
+		{name: "no scalar", paths: []string{"$.status=ok"}, body: `{"status":{}}`, reason: "no scalar at $.status"},
+		{name: "not JSON", paths: []string{"$.status"}, body: "<html>",
+			reason: "body is not JSON: invalid character '<' looking for beginning of value"},
+	}
+	for _, test := range tests {
+		expectContains, expectJSONPaths = nil, nil
+		for _, value := range test.contains {
+			if err := expectContains.Set(value); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		for _, value := range test.paths {
+			if err := expectJSONPaths.Set(value); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if reason := unexpectedBody([]byte(test.body)); reason != test.reason {
+			t.Errorf("%s: unexpectedBody(%q) = %q, expected %q", test.name, test.body, reason, test.reason)
+		}
+	}
+}
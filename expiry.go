@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// expiryWarning is the remaining token lifetime below which a warning is logged when the measurement starts
+const expiryWarning = 10 * time.Minute
+
+// tokenAudience is the aud claim, either a single audience or a list of audiences
+type tokenAudience string
+
+func (a *tokenAudience) UnmarshalJSON(data []byte) error {
+	var audiences []string
+	if json.Unmarshal(data, &audiences) == nil {
+		*a = tokenAudience(strings.Join(audiences, ", "))
+		return nil
+	}
+	return json.Unmarshal(data, (*string)(a))
+}
+
+// tokenClaims are the claims of a JWT access token used by the tool
+type tokenClaims struct {
+	Exp int64         `json:"exp"`
+	Aud tokenAudience `json:"aud"`
+	Tid string        `json:"tid"`
+	// Oid is the object ID of the user or service principal
+	Oid string `json:"oid"`
+	// Appid (v1.0 tokens) and Azp (v2.0 tokens) are the client ID of the application
+	Appid string `json:"appid"`
+	Azp   string `json:"azp"`
+}
+
+// parseTokenClaims decodes the claims of a JWT access token without verifying its signature
+func parseTokenClaims(token string) (tokenClaims, bool) {
+	var claims tokenClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, false
+	}
+	err = json.Unmarshal(payload, &claims)
+	return claims, err == nil
+}
+
+// tokenExpiry returns the expiration time of a JWT access token
+func tokenExpiry(token string) (time.Time, bool) {
+	claims, ok := parseTokenClaims(token)
+	if !ok || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/template"
+	"time"
+)
+
+// goRateLimiterTemplate is the Go client limiter emitted by 'arl export -format go-ratelimiter'
+var goRateLimiterTemplate = template.Must(template.New("go-ratelimiter").Parse(`// Client side rate limiter generated by arl from the measurement of {{.Method}} {{.URL}} on {{.Timestamp}}:
+// {{.Accepted}} requests accepted in {{.Elapsed}} before throttling, recovery after {{.Recovery}}.
+package ratelimit
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// Rate is the sustained number of requests per second, keeping {{.Headroom}}% of the measured limit in reserve
+	Rate = {{printf "%.4f" .Rate}}
+	// Burst is the number of requests which may be sent at once
+	Burst = {{.Burst}}
+
+	// InitialBackoff is the delay before retrying a throttled request
+	InitialBackoff = {{.InitialBackoff}} * time.Millisecond
+	// BackoffMultiplier grows the delay of every next retry
+	BackoffMultiplier = {{.Multiplier}}
+	// MaxBackoff is the longest delay between two retries
+	MaxBackoff = {{.MaxBackoff}} * time.Millisecond
+	// MaxRetries is the number of retries before giving up
+	MaxRetries = {{.Retries}}
+)
+
+// NewLimiter returns a limiter keeping the client within the measured rate limit
+func NewLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(Rate), Burst)
+}
+`))
+
+// goRateLimiter parameterizes the Go client limiter
+type goRateLimiter struct {
+	Method         string
+	URL            string
+	Timestamp      string
+	Accepted       uint64
+	Elapsed        time.Duration
+	Recovery       time.Duration
+	Headroom       float64
+	Rate           float64
+	Burst          int
+	InitialBackoff int64
+	Multiplier     int
+	MaxBackoff     int64
+	Retries        int
+}
+
+// loadLastRecord reads the last run recorded in an audit log or a result file
+func loadLastRecord(path string) (*auditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var last *auditRecord
+	decoder := json.NewDecoder(file)
+	for {
+		record := &auditRecord{}
+		err := decoder.Decode(record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the results: %v", err)
+		}
+		last = record
+	}
+	if last == nil {
+		return nil, errors.New("no run recorded")
+	}
+	return last, nil
+}
+
+// newGoRateLimiter derives the client limiter from the most restrictive throttled result of the run: the accepted
+// requests are the burst which the quota window, the measurement plus the recovery time, replenishes
+func newGoRateLimiter(record *auditRecord, headroom float64) (*goRateLimiter, error) {
+	var limiting *auditResult
+	var limitingRate float64
+	for i := range record.Results {
+		result := &record.Results[i]
+		if !result.Throttled || result.Accepted == 0 {
+			continue
+		}
+		window := result.Elapsed + result.RetryAfter
+		if window <= 0 {
+			continue
+		}
+		rate := float64(result.Accepted) / window
+		if limiting == nil || rate < limitingRate {
+			limiting = result
+			limitingRate = rate
+		}
+	}
+	if limiting == nil {
+		return nil, errors.New("no measurement of the run reached the rate limit")
+	}
+
+	budget := 1 - headroom/100
+	rate := limitingRate * budget
+	initialBackoff, maxBackoff := backoff(time.Duration(limiting.RetryAfter*float64(time.Second)), rate)
+	return &goRateLimiter{
+		Method:         limiting.Method,
+		URL:            limiting.URL,
+		Timestamp:      record.Timestamp.Format(time.RFC3339),
+		Accepted:       limiting.Accepted,
+		Elapsed:        time.Duration(limiting.Elapsed * float64(time.Second)).Round(time.Millisecond),
+		Recovery:       time.Duration(limiting.RetryAfter * float64(time.Second)).Round(time.Millisecond),
+		Headroom:       headroom,
+		Rate:           rate,
+		Burst:          int(math.Max(1, math.Floor(float64(limiting.Accepted)*budget))),
+		InitialBackoff: initialBackoff.Milliseconds(),
+		Multiplier:     backoffMultiplier,
+		MaxBackoff:     maxBackoff.Milliseconds(),
+		Retries:        backoffRetries,
+	}, nil
+}
+
+// exportResults prints the client configuration derived from the last recorded run in the given format
+func exportResults(w io.Writer, path string, format string) error {
+	record, err := loadLastRecord(path)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "go-ratelimiter":
+		limiter, err := newGoRateLimiter(record, fleetHeadroom)
+		if err != nil {
+			return err
+		}
+		return goRateLimiterTemplate.Execute(w, limiter)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
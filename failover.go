@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net/url"
+)
+
+// failoverTarget returns the target with the host of its URL replaced by the secondary host
+func failoverTarget(target probeTarget, host string) (probeTarget, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return target, err
+	}
+	u.Host = host
+	target.URL = u.String()
+	return target, nil
+}
+
+// measureFailover continues measuring on the secondary host once the primary throttled, to find out whether
+// the geo-redundant endpoints share the same quota
+func measureFailover(target probeTarget, token string, profile tokenProfile, primary measurement, abort chan struct{}) {
+	secondary, err := failoverTarget(target, secondaryHost)
+	if err != nil {
+		log.Printf("failed to fail over to the secondary host: %v", err)
+		return
+	}
+
+	log.Printf("Primary host throttled %s, failing over to %s", profile.name, secondary.URL)
+	barrier := newStartBarrier(1)
+	go barrier.open()
+	profile.name += "-secondary"
+	result := measureRatelimit(secondary, token, profile, barrier, abort)
+
+	switch {
+	case !result.throttled:
+		log.Printf("Secondary host did not throttle %s after %d requests, the endpoints do not share the quota",
+			profile.name, result.accepted)
+	case result.accepted <= uint64(profile.parallel):
+		log.Printf("Secondary host throttled %s immediately, the endpoints share the quota", profile.name)
+	default:
+		log.Printf("Secondary host throttled %s at %4.2f request/sec (primary %4.2f request/sec), the endpoints have separate quotas",
+			profile.name, result.rate(), primary.rate())
+	}
+}
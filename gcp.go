@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"cloud.google.com/go/compute/metadata"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcpScope is the scope of the access tokens minted from the application default credentials by default
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCPTokenSource mints the tokens of the Google application default credentials: access tokens for the Google
+// APIs, or identity tokens of an audience for Cloud Run and Cloud Endpoints
+type GCPTokenSource struct {
+	credentials *google.Credentials
+	// audience switches to identity tokens, e.g. the URL of a Cloud Run service
+	audience string
+	// identity mints the identity tokens of a service account key, nil for the other credentials
+	identity oauth2.TokenSource
+}
+
+// NewGCPTokenSource finds the application default credentials, from GOOGLE_APPLICATION_CREDENTIALS, the gcloud
+// configuration or the metadata server
+func NewGCPTokenSource(audience string, scopes []string) (*GCPTokenSource, error) {
+	if len(scopes) == 0 {
+		scopes = []string{gcpScope}
+	}
+	ctx := context.Background()
+	credentials, err := google.FindDefaultCredentials(ctx, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the application default credentials: %v", err)
+	}
+	ts := &GCPTokenSource{
+		credentials: credentials,
+		audience:    audience,
+	}
+	if audience != "" && credentials.JSON != nil {
+		var file struct {
+			Type string `json:"type"`
+		}
+		err = json.Unmarshal(credentials.JSON, &file)
+		if err != nil {
+			return nil, err
+		}
+		if file.Type == "service_account" {
+			config, err := google.JWTConfigFromJSON(credentials.JSON)
+			if err != nil {
+				return nil, err
+			}
+			config.PrivateClaims = map[string]interface{}{"target_audience": audience}
+			config.UseIDToken = true
+			ts.identity = config.TokenSource(ctx)
+		}
+	}
+	return ts, nil
+}
+
+// Token returns an access token, or an identity token when an audience is set
+func (ts *GCPTokenSource) Token() (string, error) {
+	if ts.audience == "" {
+		token, err := ts.credentials.TokenSource.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to mint the access token: %v", err)
+		}
+		return token.AccessToken, nil
+	}
+	return ts.identityToken()
+}
+
+// Refresh returns the current token, which the credentials renew only close to its expiry
+func (ts *GCPTokenSource) Refresh() (string, error) {
+	return ts.Token()
+}
+
+// identityToken mints an identity token with the service account key, the metadata server or the id_token of the
+// gcloud user
+func (ts *GCPTokenSource) identityToken() (string, error) {
+	switch {
+	case ts.identity != nil:
+		token, err := ts.identity.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to mint the identity token: %v", err)
+		}
+		return token.AccessToken, nil
+	case ts.credentials.JSON == nil && metadata.OnGCE():
+		token, err := metadata.Get("instance/service-accounts/default/identity?format=full&audience=" + url.QueryEscape(ts.audience))
+		if err != nil {
+			return "", fmt.Errorf("failed to mint the identity token with the metadata server: %v", err)
+		}
+		return token, nil
+	default:
+		token, err := ts.credentials.TokenSource.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to mint the identity token: %v", err)
+		}
+		// the user credentials of gcloud cannot choose the audience, Cloud Run accepts them anyway
+		idToken, ok := token.Extra("id_token").(string)
+		if !ok || idToken == "" {
+			return "", errors.New("the application default credentials issue no identity token, use a service account")
+		}
+		return idToken, nil
+	}
+}
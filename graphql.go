@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// graphqlThrottledCode is the error code of the throttled GraphQL operations, answered with a 200
+	graphqlThrottledCode = "THROTTLED"
+)
+
+// graphqlRequest is the body of a GraphQL operation sent over HTTP
+type graphqlRequest struct {
+	Query     string          `json:"query"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// graphqlResponse holds the errors of a GraphQL response
+type graphqlResponse struct {
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// readArgument returns the value of a flag, or the content of the file when the value is @<path>
+func readArgument(value string) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+	data, err := ioutil.ReadFile(value[1:])
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// graphqlBody returns the JSON body of the GraphQL document with its variables, both given inline or as @<path>
+func graphqlBody(query string, variables string) ([]byte, error) {
+	query, err := readArgument(query)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("empty GraphQL document")
+	}
+	request := graphqlRequest{Query: query}
+	if variables != "" {
+		variables, err = readArgument(variables)
+		if err != nil {
+			return nil, err
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(variables), &object); err != nil {
+			return nil, errors.New("the GraphQL variables must be a JSON object")
+		}
+		request.Variables = json.RawMessage(variables)
+	}
+	return json.Marshal(request)
+}
+
+// graphqlThrottled returns true when an error of the GraphQL response has the THROTTLED code
+func graphqlThrottled(body []byte) bool {
+	var response graphqlResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	for _, graphqlError := range response.Errors {
+		if graphqlError.Extensions.Code == graphqlThrottledCode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGraphqlBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	queryFile := filepath.Join(dir, "order.graphql")
+	err = ioutil.WriteFile(queryFile, []byte("query { order(id: 1) { id } }"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variablesFile := filepath.Join(dir, "variables.json")
+	err = ioutil.WriteFile(variablesFile, []byte(`{"id": 1}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query     string
+		variables string
+		body      string
+		wantErr   bool
+	}{
+		{query: "{ viewer { login } }", body: `{"query":"{ viewer { login } }"}`},
+		{query: "query($id: ID!) { order(id: $id) { id } }", variables: `{"id": "{{rand 1 10}}"}`,
+			body: `{"query":"query($id: ID!) { order(id: $id) { id } }","variables":{"id":"{{rand 1 10}}"}}`},
+		{query: "@" + queryFile, variables: "@" + variablesFile,
+			body: `{"query":"query { order(id: 1) { id } }","variables":{"id":1}}`},
+		{query: "", wantErr: true},
+		{query: "  \n", wantErr: true},
+		{query: "@" + filepath.Join(dir, "missing.graphql"), wantErr: true},
+		{query: "{ viewer { login } }", variables: `[1]`, wantErr: true},
+		{query: "{ viewer { login } }", variables: `{"id":`, wantErr: true},
+	}
+	for _, test := range tests {
+		body, err := graphqlBody(test.query, test.variables)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("graphqlBody(%q, %q) = %s, expected an error", test.query, test.variables, body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("graphqlBody(%q, %q) failed: %v", test.query, test.variables, err)
+			continue
+		}
+		if string(body) != test.body {
+			t.Errorf("graphqlBody(%q, %q) = %s, expected %s", test.query, test.variables, body, test.body)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// grpcContentType is the content type of the gRPC requests with a protobuf message
+const grpcContentType = "application/grpc+proto"
+
+// grpcHTTPStatus maps the gRPC status codes to the equivalent HTTP status, RESOURCE_EXHAUSTED (8) being the throttle
+// signal of the gRPC services
+var grpcHTTPStatus = map[int]int{
+	0:  http.StatusOK,
+	1:  499,
+	2:  http.StatusInternalServerError,
+	3:  http.StatusBadRequest,
+	4:  http.StatusGatewayTimeout,
+	5:  http.StatusNotFound,
+	6:  http.StatusConflict,
+	7:  http.StatusForbidden,
+	8:  http.StatusTooManyRequests,
+	9:  http.StatusBadRequest,
+	10: http.StatusConflict,
+	11: http.StatusBadRequest,
+	12: http.StatusNotImplemented,
+	13: http.StatusInternalServerError,
+	14: http.StatusServiceUnavailable,
+	15: http.StatusInternalServerError,
+	16: http.StatusUnauthorized,
+}
+
+// grpcTarget returns the unary call of the method of the service at the resource, e.g. /orders.v1.Orders/GetOrder,
+// with the serialized protobuf message as request
+func grpcTarget(client *http.Client, method string, message []byte) probeTarget {
+	header := http.Header{}
+	header.Set("Content-Type", grpcContentType)
+	header.Set("TE", "trailers")
+	return probeTarget{
+		client: client,
+		method: http.MethodPost,
+		URL:    strings.TrimSuffix(resource, "/") + "/" + strings.TrimPrefix(method, "/"),
+		header: header,
+		body:   grpcFrame(message),
+	}
+}
+
+// grpcFrame prefixes the uncompressed message with its length
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcProtocols restricts a transport to HTTP/2, with prior knowledge for the plaintext endpoints
+func grpcProtocols() *http.Protocols {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+// translateGRPCStatus replaces the status code of a consumed gRPC response by the HTTP equivalent of its gRPC status,
+// read from the trailers or from the headers of a trailers-only response, and returns the gRPC status message
+func translateGRPCStatus(resp *http.Response) string {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		return ""
+	}
+	status, message := resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		// a response without a valid status is an UNKNOWN error
+		code = 2
+	}
+	if statusCode, ok := grpcHTTPStatus[code]; ok {
+		resp.StatusCode = statusCode
+	} else {
+		resp.StatusCode = http.StatusInternalServerError
+	}
+	resp.Status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	if code == 0 {
+		return ""
+	}
+	return "grpc-status " + strconv.Itoa(code) + ": " + message
+}
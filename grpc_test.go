@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTranslateGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		contentType string
+		header      http.Header
+		trailer     http.Header
+		translated  int
+		message     string
+	}{
+		{name: "ok in the trailers", statusCode: http.StatusOK, contentType: "application/grpc",
+			trailer: http.Header{"Grpc-Status": {"0"}}, translated: http.StatusOK},
+		{name: "resource exhausted in the trailers", statusCode: http.StatusOK, contentType: "application/grpc+proto",
+			trailer:    http.Header{"Grpc-Status": {"8"}, "Grpc-Message": {"quota exceeded"}},
+			translated: http.StatusTooManyRequests, message: "grpc-status 8: quota exceeded"},
+		{name: "trailers-only response", statusCode: http.StatusOK, contentType: "application/grpc",
+			header:     http.Header{"Grpc-Status": {"14"}, "Grpc-Message": {"unavailable"}},
+			translated: http.StatusServiceUnavailable, message: "grpc-status 14: unavailable"},
+		{name: "unauthenticated", statusCode: http.StatusOK, contentType: "application/grpc",
+			trailer: http.Header{"Grpc-Status": {"16"}}, translated: http.StatusUnauthorized, message: "grpc-status 16: "},
+		{name: "missing status", statusCode: http.StatusOK, contentType: "application/grpc",
+			translated: http.StatusInternalServerError, message: "grpc-status 2: "},
+		{name: "unknown status", statusCode: http.StatusOK, contentType: "application/grpc",
+			trailer: http.Header{"Grpc-Status": {"42"}}, translated: http.StatusInternalServerError, message: "grpc-status 42: "},
+		{name: "not gRPC", statusCode: http.StatusOK, contentType: "application/json",
+			trailer: http.Header{"Grpc-Status": {"8"}}, translated: http.StatusOK},
+		{name: "HTTP error", statusCode: http.StatusBadGateway, contentType: "application/grpc",
+			trailer: http.Header{"Grpc-Status": {"8"}}, translated: http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		header := http.Header{"Content-Type": {test.contentType}}
+		for name, values := range test.header {
+			header[name] = values
+		}
+		resp := &http.Response{StatusCode: test.statusCode, Header: header, Trailer: test.trailer}
+		message := translateGRPCStatus(resp)
+		if resp.StatusCode != test.translated || message != test.message {
+			t.Errorf("%s: translated to %d %q, expected %d %q", test.name, resp.StatusCode, message, test.translated,
+				test.message)
+		}
+	}
+}
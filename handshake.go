@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// tlsHandshakes counts the TLS handshakes performed by the probes of a measurement
+type tlsHandshakes struct {
+	full    uint64
+	resumed uint64
+	failed  uint64
+}
+
+// trace returns the client trace which records the handshakes
+func (h *tlsHandshakes) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			switch {
+			case err != nil:
+				atomic.AddUint64(&h.failed, 1)
+			case state.DidResume:
+				atomic.AddUint64(&h.resumed, 1)
+			default:
+				atomic.AddUint64(&h.full, 1)
+			}
+		},
+	}
+}
+
+// report logs the handshakes separately from the HTTP requests
+func (h *tlsHandshakes) report(name string, elapsed time.Duration) {
+	full := atomic.LoadUint64(&h.full)
+	resumed := atomic.LoadUint64(&h.resumed)
+	failed := atomic.LoadUint64(&h.failed)
+	total := full + resumed + failed
+	if total == 0 {
+		return
+	}
+	log.Printf("TLS handshakes of %s: %d full, %d resumed, %d failed (%4.2f handshakes/sec)",
+		name, full, resumed, failed, float64(total)/elapsed.Seconds())
+}
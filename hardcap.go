@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// hardCap spaces out all the probes so that their overall rate never exceeds a ceiling, regardless of the profiles
+type hardCap struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newHardCap returns a ceiling of rate requests per second, nil when there is no ceiling
+func newHardCap(rate float64) *hardCap {
+	if rate <= 0 {
+		return nil
+	}
+	return &hardCap{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next probe is allowed to be sent
+func (c *hardCap) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	at := c.next
+	c.next = c.next.Add(c.interval)
+	c.lock.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// warnHardCap logs a warning when the profiles of the tokens want more than the ceiling
+func warnHardCap(rate float64, numTokens int) {
+	if rate <= 0 {
+		return
+	}
+	var wanted float64
+	for i := 0; i < numTokens; i++ {
+		profile := profiles.profile(i)
+		if profile.rate == 0 {
+			log.Printf("Warning: %s has no rate limit, the probes are capped at %4.2f request/sec", profile.name, rate)
+			return
+		}
+		wanted += profile.rate
+	}
+	if wanted > rate {
+		log.Printf("Warning: the profiles want %4.2f request/sec, the probes are capped at %4.2f request/sec", wanted, rate)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	heatmapColumns  = 60
+	heatmapRows     = 16
+	heatmapCellSize = 12
+	// heatmapBaseLatency is the upper bound of the lowest latency bucket, each next bucket doubles it
+	heatmapBaseLatency = time.Millisecond
+)
+
+// latencyCounts accumulates the number of samples per second since the start of a measurement and latency bucket,
+// so that the heatmap does not need the samples themselves
+type latencyCounts struct {
+	start   time.Time
+	seconds [][heatmapRows]int
+}
+
+func (lc *latencyCounts) add(s sample) {
+	second := int(s.start.Sub(lc.start) / time.Second)
+	if second < 0 {
+		second = 0
+	}
+	for len(lc.seconds) <= second {
+		lc.seconds = append(lc.seconds, [heatmapRows]int{})
+	}
+	lc.seconds[second][latencyRow(s.latency)]++
+}
+
+// heatmap counts the samples per time bucket (column) and latency bucket (row)
+type heatmap struct {
+	bucket time.Duration
+	counts [heatmapRows][]int
+	max    int
+}
+
+func newHeatmap(latencies *latencyCounts) *heatmap {
+	perBucket := len(latencies.seconds)/heatmapColumns + 1
+	columns := (len(latencies.seconds)-1)/perBucket + 1
+
+	h := &heatmap{bucket: time.Duration(perBucket) * time.Second}
+	for row := range h.counts {
+		h.counts[row] = make([]int, columns)
+	}
+	for second, counts := range latencies.seconds {
+		column := second / perBucket
+		for row, count := range counts {
+			h.counts[row][column] += count
+			if h.counts[row][column] > h.max {
+				h.max = h.counts[row][column]
+			}
+		}
+	}
+	return h
+}
+
+// latencyRow returns the logarithmic latency bucket of a latency
+func latencyRow(latency time.Duration) int {
+	row := 0
+	for bound := heatmapBaseLatency; latency >= bound && row < heatmapRows-1; bound *= 2 {
+		row++
+	}
+	return row
+}
+
+func rowLabel(row int) string {
+	if row == heatmapRows-1 {
+		return fmt.Sprintf(">= %v", heatmapBaseLatency<<uint(row-1))
+	}
+	return fmt.Sprintf("< %v", heatmapBaseLatency<<uint(row))
+}
+
+// intensity returns the color of a cell, from white (no samples) to red (most samples)
+func (h *heatmap) intensity(count int) color.RGBA {
+	if count == 0 || h.max == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	level := uint8(225 * count / h.max)
+	return color.RGBA{255, 230 - level, 230 - level, 255}
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Latency heatmap of {{.Name}}</title>
+<style>
+table { border-collapse: collapse; font-family: sans-serif; font-size: 11px; }
+td { width: 12px; height: 12px; padding: 0; }
+th { text-align: right; padding-right: 4px; font-weight: normal; }
+</style>
+</head>
+<body>
+<h1>Latency heatmap of {{.Name}}</h1>
+<p>Each column is {{.Bucket}}, the darker the cell the more requests completed with that latency.</p>
+<table>
+{{range .Rows}}<tr><th>{{.Label}}</th>{{range .Cells}}<td style="background-color: {{.Color}}" title="{{.Count}} requests"></td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type heatmapCell struct {
+	Color template.CSS
+	Count int
+}
+
+type heatmapRow struct {
+	Label string
+	Cells []heatmapCell
+}
+
+func (h *heatmap) writeHTML(file *os.File, name string) error {
+	var rows []heatmapRow
+	// the highest latencies are rendered on top
+	for row := heatmapRows - 1; row >= 0; row-- {
+		r := heatmapRow{Label: rowLabel(row)}
+		for _, count := range h.counts[row] {
+			c := h.intensity(count)
+			r.Cells = append(r.Cells, heatmapCell{
+				Color: template.CSS(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)),
+				Count: count,
+			})
+		}
+		rows = append(rows, r)
+	}
+	return heatmapTemplate.Execute(file, struct {
+		Name   string
+		Bucket time.Duration
+		Rows   []heatmapRow
+	}{name, h.bucket, rows})
+}
+
+func (h *heatmap) writePNG(file *os.File) error {
+	columns := len(h.counts[0])
+	img := image.NewRGBA(image.Rect(0, 0, columns*heatmapCellSize, heatmapRows*heatmapCellSize))
+	for row := 0; row < heatmapRows; row++ {
+		for column, count := range h.counts[row] {
+			c := h.intensity(count)
+			y0 := (heatmapRows - 1 - row) * heatmapCellSize
+			x0 := column * heatmapCellSize
+			for y := y0; y < y0+heatmapCellSize; y++ {
+				for x := x0; x < x0+heatmapCellSize; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return png.Encode(file, img)
+}
+
+// heatmapPath inserts the name of the measurement in the configured heatmap file name
+func heatmapPath(path string, name string) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), name, ext)
+}
+
+// writeHeatmap renders the latency distribution over time as an HTML page or a PNG image
+func writeHeatmap(path string, name string, latencies *latencyCounts) error {
+	if len(latencies.seconds) == 0 {
+		return nil
+	}
+	file, err := os.Create(heatmapPath(path, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := newHeatmap(latencies)
+	if strings.EqualFold(filepath.Ext(path), ".png") {
+		return h.writePNG(file)
+	}
+	return h.writeHTML(file, name)
+}
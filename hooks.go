@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execHookPrefix marks a hook executed as a shell command instead of an HTTP call
+const execHookPrefix = "exec:"
+
+// runHook executes a setup or teardown hook, either an HTTP call such as "PUT https://api/sandbox" or a shell
+// command such as "exec:./create-sandbox.sh". The HTTP call is only sent with the token of the measurement when it
+// is not empty, see hookCredential
+func runHook(spec string, client *http.Client, token string) error {
+	if strings.HasPrefix(spec, execHookPrefix) {
+		return execHook(strings.TrimPrefix(spec, execHookPrefix), nil)
+	}
+
+	method, hookURL, err := parseHook(spec)
+	if err != nil {
+		return err
+	}
+	var resp *http.Response
+	if token != "" {
+		var probeResp *probeResponse
+		probeResp, err = send(context.Background(), probeTarget{client: client, method: method, URL: hookURL}, token)
+		if probeResp != nil {
+			resp = probeResp.Response
+		}
+	} else {
+		var req *http.Request
+		req, err = http.NewRequest(method, hookURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err = client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// parseHook returns the method and the URL of an HTTP hook
+func parseHook(spec string) (string, string, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid hook %q, expected '<METHOD> <URL>' or 'exec:<command>'", spec)
+	}
+	return strings.ToUpper(parts[0]), parts[1], nil
+}
+
+// hookCredential returns the token sent with the HTTP hooks, empty unless -hook-token opts in and the hosts of all
+// the HTTP hooks pass the safety config
+func hookCredential(config *safetyConfig, token string, specs ...string) (string, error) {
+	if !hookToken {
+		return "", nil
+	}
+	for _, spec := range specs {
+		if spec == "" || strings.HasPrefix(spec, execHookPrefix) {
+			continue
+		}
+		_, hookURL, err := parseHook(spec)
+		if err != nil {
+			return "", err
+		}
+		err = checkTargetSafety(config, hookURL)
+		if err != nil {
+			return "", fmt.Errorf("the token is not sent to the hook: %v", err)
+		}
+	}
+	return token, nil
+}
+
+// execHook runs a shell command with additional environment variables, its output goes to stderr
+func execHook(command string, env []string) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// runEnv returns the metadata of the run exposed to the pre and post commands
+func runEnv(record *auditRecord) []string {
+	return []string{
+		"ARL_TARGET=" + record.Target,
+		"ARL_OPERATOR=" + record.Operator,
+		"ARL_TIMESTAMP=" + record.Timestamp.Format(time.RFC3339),
+		"ARL_CONFIG_HASH=" + record.ConfigHash,
+		fmt.Sprintf("ARL_TOKENS=%d", record.Tokens),
+		fmt.Sprintf("ARL_PARALLEL=%d", record.Parallel),
+	}
+}
+
+// runPostCommand writes the result of the run to a JSON file and runs the post command with its path in
+// ARL_RESULT_PATH
+func runPostCommand(command string, record *auditRecord) {
+	if command == "" {
+		return
+	}
+	file, err := ioutil.TempFile("", "arl-result-*.json")
+	if err != nil {
+		log.Printf("failed to create the result file: %v", err)
+		return
+	}
+	record.lock.Lock()
+	err = json.NewEncoder(file).Encode(record)
+	record.lock.Unlock()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("failed to write the result file: %v", err)
+		return
+	}
+
+	log.Printf("Running the post command")
+	err = execHook(command, append(runEnv(record), "ARL_RESULT_PATH="+file.Name()))
+	if err != nil {
+		log.Printf("failed to run the post command: %v", err)
+	}
+}
+
+// runTeardown executes the teardown hook, logging instead of failing since the measurement is already over
+func runTeardown(spec string, client *http.Client, token string) {
+	if spec == "" {
+		return
+	}
+	log.Printf("Running the teardown hook")
+	err := runHook(spec, client, token)
+	if err != nil {
+		log.Printf("failed to run the teardown hook: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// envScheme prefixes the secrets of the identities file read from an environment variable
+const envScheme = "env:"
+
+// identity is a principal of the identities file, a service principal with its secret or a user with its password
+type identity struct {
+	name         string
+	tenantID     string
+	clientID     string
+	clientSecret string
+	username     string
+	password     string
+}
+
+// identityColumns are the columns of the identities file, the first line names the columns used
+var identityColumns = []string{"name", "tenant_id", "client_id", "client_secret", "username", "password"}
+
+// loadIdentities reads the identities of a CSV file, the tenant and client IDs default to the flags
+func loadIdentities(path string) ([]identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("expected a header line with the columns %s and at least one identity",
+			strings.Join(identityColumns, ", "))
+	}
+
+	columns := make(map[string]int)
+	for i, column := range records[0] {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !contains(identityColumns, column) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", column, strings.Join(identityColumns, ", "))
+		}
+		columns[column] = i
+	}
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var identities []identity
+	for line, record := range records[1:] {
+		id := identity{
+			name:         field(record, "name"),
+			tenantID:     firstNonEmpty(field(record, "tenant_id"), tenantID),
+			clientID:     firstNonEmpty(field(record, "client_id"), clientID),
+			clientSecret: field(record, "client_secret"),
+			username:     field(record, "username"),
+			password:     field(record, "password"),
+		}
+		if id.clientSecret == "" && (id.username == "" || id.password == "") {
+			return nil, fmt.Errorf("line %d: expected a client secret, or a username and a password", line+2)
+		}
+		if id.name == "" {
+			id.name = firstNonEmpty(id.username, id.clientID)
+		}
+		if (isPlaintextSecret(id.clientSecret) || isPlaintextSecret(id.password)) && !isPrivateFile(info) {
+			return nil, fmt.Errorf("line %d: the secrets of %s are in plaintext in a file readable by other users, "+
+				"restrict its permissions to its owner (chmod 600) or use %s<variable> or %s<vault>/<secret> references",
+				line+2, id.name, envScheme, keyVaultScheme)
+		}
+		identities = append(identities, id)
+	}
+	return identities, nil
+}
+
+// isPlaintextSecret returns true when a secret of the identities file is not a reference
+func isPlaintextSecret(secret string) bool {
+	return secret != "" && !strings.HasPrefix(secret, envScheme) && !strings.HasPrefix(secret, keyVaultScheme)
+}
+
+// isPrivateFile returns true when the file is neither readable by its group nor by the other users, the Windows
+// files have no such permission bits
+func isPrivateFile(info os.FileInfo) bool {
+	return runtime.GOOS == "windows" || info.Mode().Perm()&0077 == 0
+}
+
+// resolveIdentitySecret reads a secret of the identities file from its environment variable or its Key Vault
+func resolveIdentitySecret(secret string) (string, error) {
+	if !strings.HasPrefix(secret, envScheme) {
+		return resolveSecret(secret)
+	}
+	name := strings.TrimPrefix(secret, envScheme)
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("the environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// contains returns true when the value is one of the values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenSource creates the token source of the identity, signing in the users with their password
+func (id identity) tokenSource(resource string) (TokenSource, error) {
+	source, err := NewAzureTokenSource(id.tenantID, id.clientID, resource)
+	if err != nil {
+		return nil, err
+	}
+	source.clientSecret, err = resolveIdentitySecret(id.clientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the client secret of %s: %v", id.name, err)
+	}
+	source.password, err = resolveIdentitySecret(id.password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the password of %s: %v", id.name, err)
+	}
+	source.username = id.username
+	source.scopes = scopeList()
+	return source, nil
+}
+
+// newIdentityPool creates the pool of an identities file, every token is acquired by a distinct principal
+func newIdentityPool(path string) (*tokenPool, error) {
+	identities, err := loadIdentities(path)
+	if err != nil {
+		return nil, err
+	}
+	audience, err := resourceAudience()
+	if err != nil {
+		return nil, err
+	}
+	var sources []TokenSource
+	for i, id := range identities {
+		source, err := id.tokenSource(audience)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+		// label the measurements with the identities unless a profile names them
+		if profile := profiles[i]; profile.name == "" {
+			profile.name = id.name
+			profiles[i] = profile
+		}
+	}
+	return newMultiSourceTokenPool(sources), nil
+}
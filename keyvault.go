@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	keyVaultScheme  = "keyvault://"
+	keyVaultVersion = "7.4"
+)
+
+// keyVaultName matches the names of the vaults, 3 to 24 letters, digits and dashes
+var keyVaultName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,22}[a-z0-9]$`)
+
+// keyVaultError is the error payload returned by Key Vault
+type keyVaultError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// resolveSecret returns the value as is, unless it is a keyvault://<vault>/<secret>[/<version>] reference
+// in which case the secret is fetched from Key Vault with the managed identity of the host
+func resolveSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, keyVaultScheme) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, keyVaultScheme), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid Key Vault reference %q, expected %s<vault>/<secret>[/<version>]", value, keyVaultScheme)
+	}
+	// the managed identity token is only sent to the vaults of the cloud, never to another host
+	vault := strings.TrimSuffix(strings.ToLower(parts[0]), "."+activeCloud.keyVaultDomain)
+	if !keyVaultName.MatchString(vault) {
+		return "", fmt.Errorf("invalid Key Vault %q, expected a vault name or a host of %s", parts[0], activeCloud.keyVaultDomain)
+	}
+	vault = fmt.Sprintf("%s.%s", vault, activeCloud.keyVaultDomain)
+	secretURL := fmt.Sprintf("https://%s/secrets/%s", vault, strings.Join(parts[1:], "/"))
+
+	token, err := managedIdentityToken(activeCloud.keyVaultResource, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire the Key Vault token with the managed identity: %v", err)
+	}
+	secret, err := fetchKeyVaultSecret(secretURL, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the secret %s: %v", secretURL, err)
+	}
+	return secret, nil
+}
+
+// fetchKeyVaultSecret reads the current value of a Key Vault secret
+func fetchKeyVaultSecret(secretURL string, token string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, secretURL+"?api-version="+keyVaultVersion, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var kvErr keyVaultError
+		if json.NewDecoder(resp.Body).Decode(&kvErr) == nil && kvErr.Error.Message != "" {
+			return "", fmt.Errorf("%s: %s", kvErr.Error.Code, kvErr.Error.Message)
+		}
+		return "", fmt.Errorf("Key Vault returned %s", resp.Status)
+	}
+	var secret struct {
+		Value string `json:"value"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var reportedPercentiles = []float64{50, 90, 99, 99.9}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(p / 100 * float64(len(sorted)))
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+func formatPercentiles(latencies []time.Duration) string {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var parts []string
+	for _, p := range reportedPercentiles {
+		parts = append(parts, fmt.Sprintf("p%g %v", p, percentile(latencies, p)))
+	}
+	parts = append(parts, fmt.Sprintf("max %v", latencies[len(latencies)-1]))
+	return strings.Join(parts, ", ")
+}
+
+// correctedLatencies compensates the coordinated omission of the closed-loop workers. Samples sent on a
+// schedule are measured from their intended send time, the others get the synthetic samples they would
+// have produced every expected interval while the worker was stalled.
+func correctedLatencies(samples []sample, expectedInterval time.Duration) []time.Duration {
+	var latencies []time.Duration
+	for _, s := range samples {
+		if !s.intended.IsZero() {
+			latencies = append(latencies, s.start.Add(s.latency).Sub(s.intended))
+			continue
+		}
+		latencies = append(latencies, s.latency)
+		if expectedInterval <= 0 {
+			continue
+		}
+		for missed := s.latency - expectedInterval; missed >= expectedInterval; missed -= expectedInterval {
+			latencies = append(latencies, missed)
+		}
+	}
+	return latencies
+}
+
+// reportLatency logs the latency percentiles of a measurement
+func reportLatency(name string, samples []sample, correct bool) {
+	if len(samples) == 0 {
+		return
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		latencies = append(latencies, s.latency)
+	}
+	log.Printf("Latency of %s: %s", name, formatPercentiles(latencies))
+	if correct {
+		// a closed-loop worker is expected to send a probe every median latency
+		expectedInterval := percentile(latencies, 50)
+		log.Printf("Latency of %s corrected for coordinated omission: %s", name,
+			formatPercentiles(correctedLatencies(samples, expectedInterval)))
+	}
+}
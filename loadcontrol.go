@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+// loadControl broadcasts the runtime adjustments of the load to the running measurements
+type loadControl struct {
+	lock        sync.Mutex
+	subscribers map[chan float64]struct{}
+}
+
+// load adjusts the parallelism and the rate of the running measurements
+var load = &loadControl{subscribers: make(map[chan float64]struct{})}
+
+// subscribe returns a channel receiving the factors scaling the load of a measurement
+func (lc *loadControl) subscribe() chan float64 {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	adjustments := make(chan float64, 1)
+	lc.subscribers[adjustments] = struct{}{}
+	return adjustments
+}
+
+func (lc *loadControl) unsubscribe(adjustments chan float64) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	delete(lc.subscribers, adjustments)
+}
+
+// scale multiplies the parallelism and the rate of all the running measurements by the factor
+func (lc *loadControl) scale(factor float64) {
+	lc.lock.Lock()
+	defer lc.lock.Unlock()
+	log.Printf("Scaling the load of %d measurements by %.2f", len(lc.subscribers), factor)
+	for adjustments := range lc.subscribers {
+		// a measurement which has not applied the previous adjustment yet gets the combined one
+		combined := factor
+		select {
+		case pending := <-adjustments:
+			combined *= pending
+		default:
+		}
+		adjustments <- combined
+	}
+}
+
+// scaleParallelism returns the scaled number of workers, at least one
+func scaleParallelism(parallel int, factor float64) int {
+	scaled := int(math.Round(float64(parallel) * factor))
+	if scaled == parallel {
+		// make sure that every step changes the parallelism
+		if factor > 1 {
+			scaled++
+		} else if factor < 1 {
+			scaled--
+		}
+	}
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// formatRate formats a request rate where 0 means unlimited
+func formatRate(rate float64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%4.2f request/sec", rate)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleLoadSignals steps the load up on SIGUSR2 and down on SIGUSR1 by the given percentage
+func handleLoadSignals(step float64) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR2 {
+				load.scale(1 + step/100)
+			} else {
+				load.scale(1 / (1 + step/100))
+			}
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// handleLoadSignals does nothing since there are no user signals on Windows
+func handleLoadSignals(step float64) {}
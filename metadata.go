@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// metadataProbes is the number of probes of each metadata method sent once the rate limit is reached
+	metadataProbes = 5
+	// metadataAttempts bounds the probes of the target sent to keep the rate limit reached during the test
+	metadataAttempts = 4 * metadataProbes
+)
+
+// preflightTarget returns the target of the CORS preflight requests a browser sends from the origin before the
+// requests of the target
+func preflightTarget(target probeTarget, origin string) probeTarget {
+	header := http.Header{}
+	for name, values := range target.header {
+		if name != "Content-Type" {
+			header[name] = values
+		}
+	}
+	header.Set("Origin", origin)
+	header.Set("Access-Control-Request-Method", target.method)
+	if names := credentialHeaders(); len(names) > 0 {
+		header.Set("Access-Control-Request-Headers", strings.Join(names, ","))
+	}
+	return probeTarget{client: target.client, method: http.MethodOptions, URL: target.URL, header: header}
+}
+
+// credentialHeaders returns the sorted lowercase names of the headers carrying the credential of the requests, which
+// a browser lists in its CORS preflight, none for the anonymous requests and the session cookies that a browser sends
+// as credentials without listing them
+func credentialHeaders() []string {
+	var names []string
+	switch {
+	case len(apimKeys) > 0:
+		names = []string{apimSubscriptionKeyHeader}
+	case apiKey != "":
+		names = []string{apiKeyHeader}
+	case hmacKey != "":
+		names = []string{hmacHeader}
+		if hmacDateHeader != "" {
+			names = append(names, hmacDateHeader)
+		}
+	case authMode == authSigV4:
+		names = []string{"Authorization", "X-Amz-Content-Sha256", "X-Amz-Date"}
+	case authMode == authNone, authMode == authSession:
+		return nil
+	default:
+		names = []string{"Authorization"}
+	}
+	for i, name := range names {
+		names[i] = strings.ToLower(name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isPreflight returns true for a CORS preflight request, which a browser sends without credentials
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// hasBody returns false for the responses which have no content to check, e.g. the responses of the HEAD and of the
+// CORS preflight requests
+func hasBody(req *http.Request, statusCode int) bool {
+	switch {
+	case req.Method == http.MethodHead, isPreflight(req):
+		return false
+	case statusCode < http.StatusOK, statusCode == http.StatusNoContent, statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// testMetadataQuota checks whether the HEAD and the CORS preflight requests are throttled once the rate limit of the
+// target is reached, i.e. whether the metadata requests share its quota. Every metadata probe follows a probe of the
+// target, it is only counted when the target was still throttled, since the limit may have reset in the meantime
+func testMetadataQuota(target probeTarget, token string, abort chan struct{}) {
+	head := target
+	head.method = http.MethodHead
+	head.body = nil
+	for _, metadata := range []probeTarget{head, preflightTarget(target, preflightOrigin)} {
+		kind := metadata.method
+		if metadata.method == http.MethodOptions {
+			kind = "CORS preflight"
+		}
+		var checked, accepted, throttled int
+		for attempt := 0; checked < metadataProbes && attempt < metadataAttempts; attempt++ {
+			select {
+			case <-abort:
+				log.Println("Aborting the metadata quota test")
+				return
+			default:
+			}
+			limited, err := sendMetadataProbe(target, token)
+			if err != nil {
+				log.Printf("failed to execute the %s probe: %v", target.method, err)
+				return
+			}
+			if !isThrottled(limited.Response) {
+				continue
+			}
+			resp, err := sendMetadataProbe(metadata, token)
+			if err != nil {
+				log.Printf("failed to execute the %s probe: %v", kind, err)
+				return
+			}
+			checked++
+			if resp.accepted {
+				accepted++
+			} else if isThrottled(resp.Response) {
+				throttled++
+			}
+		}
+		switch {
+		case checked == 0:
+			log.Printf("%s quota test inconclusive: %s was no longer throttled during %d probes", kind, target.method,
+				metadataAttempts)
+		case throttled > 0:
+			log.Printf("%s requests share the rate limit of %s: %d of %d probes were throttled", kind, target.method,
+				throttled, checked)
+		default:
+			log.Printf("%s requests do not share the rate limit of %s: %d of %d probes were accepted", kind, target.method,
+				accepted, checked)
+		}
+	}
+}
+
+// sendMetadataProbe sends a probe of the metadata quota test within the request ceiling
+func sendMetadataProbe(target probeTarget, token string) (*probeResponse, error) {
+	err := ceiling.wait(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return send(context.Background(), target, token)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCredentialHeaders(t *testing.T) {
+	defer func(keys apimSubscriptions, key, keyHeader, hmac, header, dateHeader, mode string) {
+		apimKeys, apiKey, apiKeyHeader, hmacKey, hmacHeader, hmacDateHeader, authMode = keys, key, keyHeader, hmac, header, dateHeader, mode
+	}(apimKeys, apiKey, apiKeyHeader, hmacKey, hmacHeader, hmacDateHeader, authMode)
+
+	tests := []struct {
+		name           string
+		apimKeys       apimSubscriptions
+		apiKey         string
+		apiKeyHeader   string
+		hmacKey        string
+		hmacHeader     string
+		hmacDateHeader string
+		authMode       string
+		headers        []string
+	}{
+		{name: "bearer token", authMode: authAzure, headers: []string{"authorization"}},
+		{name: "basic credentials", authMode: authNTLM, headers: []string{"authorization"}},
+		{name: "APIM subscription key", apimKeys: apimSubscriptions{{product: "starter", key: "k"}}, authMode: authAzure,
+			headers: []string{"ocp-apim-subscription-key"}},
+		{name: "API key", apiKey: "k", apiKeyHeader: "X-Api-Key", authMode: authAzure, headers: []string{"x-api-key"}},
+		{name: "HMAC signature", hmacKey: "k", hmacHeader: "X-Signature", hmacDateHeader: "x-ms-date", authMode: authAzure,
+			headers: []string{"x-ms-date", "x-signature"}},
+		{name: "HMAC signature without date", hmacKey: "k", hmacHeader: "Authorization", authMode: authAzure,
+			headers: []string{"authorization"}},
+		{name: "SigV4 signature", authMode: authSigV4,
+			headers: []string{"authorization", "x-amz-content-sha256", "x-amz-date"}},
+		{name: "anonymous", authMode: authNone, headers: nil},
+		{name: "session cookies", authMode: authSession, headers: nil},
+	}
+	for _, test := range tests {
+		apimKeys, apiKey, apiKeyHeader = test.apimKeys, test.apiKey, test.apiKeyHeader
+		hmacKey, hmacHeader, hmacDateHeader, authMode = test.hmacKey, test.hmacHeader, test.hmacDateHeader, test.authMode
+		if headers := credentialHeaders(); !reflect.DeepEqual(headers, test.headers) {
+			t.Errorf("%s: credentialHeaders() = %v, expected %v", test.name, headers, test.headers)
+		}
+	}
+}
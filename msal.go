@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+)
+
+// refreshClaims is a harmless claims request, MSAL ignores the cached access token when claims are requested and
+// redeems the refresh token instead
+const refreshClaims = `{"id_token":{"auth_time":{"essential":true}}}`
+
+// errNoAccount is returned by a silent acquisition when no user signed in before
+var errNoAccount = errors.New("no signed in account")
+
+// msalClient is the MSAL client application of the token source, a public client for the users and a confidential
+// client for the service principals
+type msalClient interface {
+	// acquireSilent returns the cached access token or redeems the cached refresh token, refresh skips the cached
+	// access token
+	acquireSilent(ctx context.Context, scopes []string, refresh bool) (public.AuthResult, error)
+	// acquire acquires a new token signing in the user or presenting the client credentials
+	acquire(ctx context.Context, scopes []string) (public.AuthResult, error)
+}
+
+// resourceScopes returns the scopes requesting the static permissions of an AAD v1 resource
+func resourceScopes(resource string) []string {
+	return []string{strings.TrimSuffix(resource, "/") + "/.default"}
+}
+
+// tenantAuthority returns the authority of the tenant in the active cloud
+func tenantAuthority(tenantID string) string {
+	return activeCloud.authority + tenantID
+}
+
+// publicClient signs in users with the device code flow, the system browser or their password
+type publicClient struct {
+	client public.Client
+	// username and password sign in a test user with the resource owner password credentials grant
+	username string
+	password string
+	// interactive opens the system browser instead of printing a device code
+	interactive bool
+	// deviceCodeJSON prints the device code payload as JSON for automation instead of a human message
+	deviceCodeJSON bool
+	// deviceCodeTimeout limits how long the device code flow waits for the user completion
+	deviceCodeTimeout time.Duration
+}
+
+func newPublicClient(tenantID string, clientID string, cache *tokenCache) (*publicClient, error) {
+	options := []public.Option{
+		public.WithAuthority(tenantAuthority(tenantID)),
+		// custom authorities, such as Azure Stack, are not known to the instance discovery of the public cloud
+		public.WithInstanceDiscovery(authorityHost == ""),
+	}
+	if cache != nil {
+		options = append(options, public.WithCache(cache.partition(tenantID, clientID)))
+	}
+	client, err := public.New(clientID, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &publicClient{client: client}, nil
+}
+
+func (pc *publicClient) acquireSilent(ctx context.Context, scopes []string, refresh bool) (public.AuthResult, error) {
+	accounts, err := pc.client.Accounts(ctx)
+	if err != nil {
+		return public.AuthResult{}, err
+	}
+	account, ok := pc.account(accounts)
+	if !ok {
+		return public.AuthResult{}, errNoAccount
+	}
+	options := []public.AcquireSilentOption{public.WithSilentAccount(account)}
+	if refresh {
+		options = append(options, public.WithClaims(refreshClaims))
+	}
+	return pc.client.AcquireTokenSilent(ctx, scopes, options...)
+}
+
+// account returns the signed in account, the one of the username when the password grant is used
+func (pc *publicClient) account(accounts []public.Account) (public.Account, bool) {
+	for _, account := range accounts {
+		if pc.username == "" || strings.EqualFold(account.PreferredUsername, pc.username) {
+			return account, true
+		}
+	}
+	return public.Account{}, false
+}
+
+func (pc *publicClient) acquire(ctx context.Context, scopes []string) (public.AuthResult, error) {
+	if pc.username != "" {
+		result, err := pc.client.AcquireTokenByUsernamePassword(ctx, scopes, pc.username, pc.password)
+		if err != nil {
+			return result, fmt.Errorf("failed to sign in %s with the password: %v", pc.username, err)
+		}
+		return result, nil
+	}
+	if pc.interactive {
+		result, err := pc.client.AcquireTokenInteractive(ctx, scopes)
+		if err != nil {
+			return result, fmt.Errorf("Failed to sign in with the browser: %s", err)
+		}
+		return result, nil
+	}
+	return pc.acquireDeviceCode(ctx, scopes)
+}
+
+func (pc *publicClient) acquireDeviceCode(ctx context.Context, scopes []string) (public.AuthResult, error) {
+	if pc.deviceCodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pc.deviceCodeTimeout)
+		defer cancel()
+	}
+	deviceCode, err := pc.client.AcquireTokenByDeviceCode(ctx, scopes)
+	if err != nil {
+		return public.AuthResult{}, fmt.Errorf("Failed to start device auth flow: %s", err)
+	}
+
+	if pc.deviceCodeJSON {
+		expiresIn := int64(time.Until(deviceCode.Result.ExpiresOn).Seconds())
+		err = json.NewEncoder(os.Stdout).Encode(deviceCodePayload{
+			UserCode:        &deviceCode.Result.UserCode,
+			VerificationURL: &deviceCode.Result.VerificationURL,
+			ExpiresIn:       &expiresIn,
+			Message:         &deviceCode.Result.Message,
+		})
+		if err != nil {
+			return public.AuthResult{}, fmt.Errorf("Failed to print the device code: %s", err)
+		}
+	} else {
+		fmt.Println(deviceCode.Result.Message)
+	}
+
+	result, err := deviceCode.AuthenticationResult(ctx)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return result, fmt.Errorf("device code flow not completed within %v", pc.deviceCodeTimeout)
+		}
+		return result, fmt.Errorf("Failed to finish device auth flow: %s", err)
+	}
+	return result, nil
+}
+
+// confidentialClient acquires the tokens of a service principal with the client credentials grant
+type confidentialClient struct {
+	client confidential.Client
+}
+
+func newConfidentialClient(tenantID string, clientID string, credential confidential.Credential) (*confidentialClient, error) {
+	client, err := confidential.New(tenantAuthority(tenantID), clientID, credential,
+		confidential.WithInstanceDiscovery(authorityHost == ""))
+	if err != nil {
+		return nil, err
+	}
+	return &confidentialClient{client: client}, nil
+}
+
+func (cc *confidentialClient) acquireSilent(ctx context.Context, scopes []string, refresh bool) (public.AuthResult, error) {
+	if refresh {
+		// there is no refresh token, the client credentials are presented again
+		return cc.acquire(ctx, scopes)
+	}
+	return cc.client.AcquireTokenSilent(ctx, scopes)
+}
+
+func (cc *confidentialClient) acquire(ctx context.Context, scopes []string) (public.AuthResult, error) {
+	result, err := cc.client.AcquireTokenByCredential(ctx, scopes)
+	if err != nil {
+		return result, fmt.Errorf("failed to acquire the token with the client credentials: %v", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// imdsEndpoint is the token endpoint of the instance metadata service serving the managed identity
+const imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// imdsTokenResponse is the access token returned by the instance metadata service
+type imdsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresOn   string `json:"expires_on"`
+}
+
+// managedIdentityToken acquires an access token for the resource from the instance metadata service, an empty
+// client ID selects the system assigned identity
+func managedIdentityToken(resource string, identityClientID string) (string, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", resource)
+	if identityClientID != "" {
+		query.Set("client_id", identityClientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, imdsEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata service returned %s", resp.Status)
+	}
+	var token imdsTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// ManagedIdentityTokenSource acquires the tokens of the managed identity of an Azure VM or AKS node
+type ManagedIdentityTokenSource struct {
+	resource string
+	// identityClientID selects a user assigned identity, empty for the system assigned one
+	identityClientID string
+}
+
+// NewManagedIdentityTokenSource creates a token source for the managed identity
+func NewManagedIdentityTokenSource(resource string, identityClientID string) *ManagedIdentityTokenSource {
+	return &ManagedIdentityTokenSource{
+		resource:         resource,
+		identityClientID: identityClientID,
+	}
+}
+
+// Token returns an access token of the managed identity
+func (ts *ManagedIdentityTokenSource) Token() (string, error) {
+	return managedIdentityToken(ts.resource, ts.identityClientID)
+}
+
+// Refresh returns the current access token of the managed identity, the instance metadata service caches the
+// tokens and only renews them close to their expiry
+func (ts *ManagedIdentityTokenSource) Refresh() (string, error) {
+	return managedIdentityToken(ts.resource, ts.identityClientID)
+}
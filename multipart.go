@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+)
+
+// formPart is a field or a file of a multipart/form-data body
+type formPart struct {
+	name  string
+	value string
+	// file is true when the value is the path of the file uploaded in the part
+	file bool
+}
+
+// formFlag appends the parts of the -form-field or the -form-file flag to the parts of the body, in their order on
+// the command line
+type formFlag struct {
+	parts *[]formPart
+	file  bool
+}
+
+func (ff formFlag) String() string {
+	if ff.parts == nil {
+		return ""
+	}
+	var parts []string
+	for _, part := range *ff.parts {
+		if part.file == ff.file {
+			parts = append(parts, part.name+"="+part.value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (ff formFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid form part %q, expected <name>=<value>", value)
+	}
+	*ff.parts = append(*ff.parts, formPart{name: parts[0], value: parts[1], file: ff.file})
+	return nil
+}
+
+// multipartBody builds the multipart/form-data body of the parts and returns it with its content type
+func multipartBody(parts []formPart) ([]byte, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if !part.file {
+			if err := writer.WriteField(part.name, part.value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(part.value)
+		if err != nil {
+			return nil, "", err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(part.value))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, part.name, filepath.Base(part.value)))
+		header.Set("Content-Type", contentType)
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// isMultipart returns true for the multipart bodies, whose files are sent as they are without rendering placeholders
+func isMultipart(contentType string) bool {
+	return strings.HasPrefix(contentType, "multipart/")
+}
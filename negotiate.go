@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+)
+
+// kerberosSigner authenticates every probe with a Kerberos service ticket wrapped in a SPNEGO (Negotiate) token
+type kerberosSigner struct {
+	client *client.Client
+	// spn is the service principal of the resource, empty for HTTP/<host>
+	spn string
+}
+
+// newKerberosSigner logs in with the tickets acquired by kinit in the credential cache, $KRB5CCNAME or
+// /tmp/krb5cc_<uid>, and the realms of the Kerberos configuration, $KRB5_CONFIG or /etc/krb5.conf. It returns the
+// signer together with the principal of the tickets.
+func newKerberosSigner(spn string) (*kerberosSigner, string, error) {
+	cfg, err := config.Load(firstNonEmpty(os.Getenv("KRB5_CONFIG"), "/etc/krb5.conf"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load the Kerberos configuration: %v", err)
+	}
+	path := os.Getenv("KRB5CCNAME")
+	if path == "" {
+		path = fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	if strings.Contains(path, ":") && !strings.HasPrefix(path, "FILE:") {
+		return nil, "", fmt.Errorf("unsupported credential cache %s, only the FILE caches are supported", path)
+	}
+	ccache, err := credentials.LoadCCache(strings.TrimPrefix(path, "FILE:"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load the Kerberos credential cache, run kinit: %v", err)
+	}
+	krbClient, err := client.NewFromCCache(ccache, cfg)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the Kerberos client: %v", err)
+	}
+	principal := ccache.DefaultPrincipal.PrincipalName.PrincipalNameString() + "@" + ccache.DefaultPrincipal.Realm
+	return &kerberosSigner{client: krbClient, spn: spn}, principal, nil
+}
+
+// sign sets the Negotiate authorization of the request with a new authenticator of the service ticket
+func (s *kerberosSigner) sign(req *http.Request) error {
+	return spnego.SetSPNEGOHeader(s.client, req, s.spn)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// GenericOAuth2TokenSource acquires the tokens of a client with the client credentials grant of a standard OAuth2
+// token endpoint, for the APIs outside of Azure
+type GenericOAuth2TokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	// assertion authenticates the client with a JWT signed by its private key instead of the secret
+	assertion *clientAssertion
+	scopes    []string
+}
+
+// NewGenericOAuth2TokenSource creates a token source for the client of an OAuth2 token endpoint
+func NewGenericOAuth2TokenSource(tokenURL string, clientID string, clientSecret string, scopes []string) (*GenericOAuth2TokenSource, error) {
+	if tokenURL == "" {
+		return nil, fmt.Errorf("the token URL is required by the %s authentication", authOAuth2)
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("the client ID and secret are required by the %s authentication", authOAuth2)
+	}
+	return &GenericOAuth2TokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}, nil
+}
+
+// NewGenericOAuth2AssertionTokenSource creates a token source for the client of an OAuth2 token endpoint which
+// authenticates with a signed JWT assertion (private_key_jwt)
+func NewGenericOAuth2AssertionTokenSource(tokenURL string, clientID string, assertion *clientAssertion, scopes []string) (*GenericOAuth2TokenSource, error) {
+	if tokenURL == "" || clientID == "" {
+		return nil, fmt.Errorf("the token URL and the client ID are required by the %s authentication", authOAuth2)
+	}
+	return &GenericOAuth2TokenSource{
+		tokenURL:  tokenURL,
+		clientID:  clientID,
+		assertion: assertion,
+		scopes:    scopes,
+	}, nil
+}
+
+// Token requests a new access token from the token endpoint
+func (ts *GenericOAuth2TokenSource) Token() (string, error) {
+	form := url.Values{
+		"grant_type": {"client_credentials"},
+		"client_id":  {ts.clientID},
+	}
+	if ts.assertion != nil {
+		assertion, err := ts.assertion.sign(ts.tokenURL)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign the client assertion: %v", err)
+		}
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	} else {
+		form.Set("client_secret", ts.clientSecret)
+	}
+	if len(ts.scopes) > 0 {
+		form.Set("scope", strings.Join(ts.scopes, " "))
+	}
+	token, err := requestToken(ts.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire the token from %s: %v", ts.tokenURL, err)
+	}
+	return token.AccessToken, nil
+}
+
+// Refresh requests a new access token, there is no refresh token in the client credentials grant
+func (ts *GenericOAuth2TokenSource) Refresh() (string, error) {
+	return ts.Token()
+}
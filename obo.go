@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+// userAssertionEnv is the environment variable holding the user assertion when the flag is not set
+const userAssertionEnv = "ARL_USER_ASSERTION"
+
+// OnBehalfOfTokenSource exchanges the token of a user calling a middle-tier service for a token of the downstream
+// resource, as the middle-tier service does with the on-behalf-of flow
+type OnBehalfOfTokenSource struct {
+	app           *confidentialClient
+	userAssertion string
+	scopes        []string
+}
+
+// NewOnBehalfOfTokenSource creates a token source exchanging the user assertion with the credential of the
+// middle-tier service
+func NewOnBehalfOfTokenSource(middleTier *AzureTokenSource, userAssertion string) (*OnBehalfOfTokenSource, error) {
+	if !middleTier.confidential() {
+		return nil, errors.New("the on-behalf-of exchange needs the client secret, certificate or key of the middle-tier service")
+	}
+	if userAssertion == "" {
+		return nil, errors.New("the user assertion is required by the on-behalf-of exchange")
+	}
+	credential, err := middleTier.credential()
+	if err != nil {
+		return nil, err
+	}
+	app, err := newConfidentialClient(middleTier.tenantID, middleTier.clientID, credential)
+	if err != nil {
+		return nil, err
+	}
+	return &OnBehalfOfTokenSource{
+		app:           app,
+		userAssertion: userAssertion,
+		scopes:        middleTier.requestScopes(),
+	}, nil
+}
+
+// Token returns the downstream token of the user, cached by MSAL until it expires
+func (ts *OnBehalfOfTokenSource) Token() (string, error) {
+	result, err := ts.app.client.AcquireTokenOnBehalfOf(context.Background(), ts.userAssertion, ts.scopes)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange the user assertion: %v", err)
+	}
+	return result.AccessToken, nil
+}
+
+// Refresh exchanges the user assertion again, or redeems the refresh token of the previous exchange
+func (ts *OnBehalfOfTokenSource) Refresh() (string, error) {
+	result, err := ts.app.client.AcquireTokenOnBehalfOf(context.Background(), ts.userAssertion, ts.scopes,
+		confidential.WithClaims(refreshClaims))
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange the user assertion: %v", err)
+	}
+	return result.AccessToken, nil
+}
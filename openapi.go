@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// politeProfile is the load used for each operation while crawling an OpenAPI spec
+var politeProfile = tokenProfile{name: "openapi", parallel: 2, duration: time.Minute}
+
+type openAPIParameter struct {
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+}
+
+type openAPIOperation struct {
+	OperationID string             `json:"operationId"`
+	Tags        []string           `json:"tags"`
+	Parameters  []openAPIParameter `json:"parameters"`
+}
+
+// openAPIOperationResult is a row of the limit matrix
+type openAPIOperationResult struct {
+	method    string
+	path      string
+	operation openAPIOperation
+	result    measurement
+	skipped   string
+}
+
+// loadOpenAPIOperations reads the safe operations of a JSON OpenAPI (or Swagger) spec
+func loadOpenAPIOperations(path string) ([]openAPIOperationResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err = json.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the OpenAPI spec: %v", err)
+	}
+
+	var operations []openAPIOperationResult
+	for path, item := range spec.Paths {
+		for key, raw := range item {
+			method := strings.ToUpper(key)
+			if method != http.MethodGet && method != http.MethodHead {
+				continue
+			}
+			var operation openAPIOperation
+			err = json.Unmarshal(raw, &operation)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the operation %s %s: %v", method, path, err)
+			}
+			operations = append(operations, openAPIOperationResult{
+				method:    method,
+				path:      path,
+				operation: operation,
+				skipped:   unsupportedOperation(path, operation),
+			})
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].path == operations[j].path {
+			return operations[i].method < operations[j].method
+		}
+		return operations[i].path < operations[j].path
+	})
+	return operations, nil
+}
+
+// unsupportedOperation returns why an operation cannot be probed without user provided values
+func unsupportedOperation(path string, operation openAPIOperation) string {
+	if strings.Contains(path, "{") {
+		return "path parameters"
+	}
+	for _, parameter := range operation.Parameters {
+		if parameter.Required && parameter.In != "path" {
+			return fmt.Sprintf("required %s parameter %s", parameter.In, parameter.Name)
+		}
+	}
+	return ""
+}
+
+// crawlOpenAPI measures every safe operation of the spec with a polite profile and prints a limit matrix
+func crawlOpenAPI(specPath string, client *http.Client, token string, interrupt chan os.Signal) {
+	operations, err := loadOpenAPIOperations(specPath)
+	if err != nil {
+		fatalf("failed to load the OpenAPI operations: %v", err)
+	}
+
+	baseURL := strings.TrimSuffix(resource, "/")
+	profile := politeProfile
+	if measureDuration > 0 {
+		profile.duration = measureDuration
+	}
+
+	abort := abortOnInterrupt(interrupt)
+
+	var last []measurement
+	for i := range operations {
+		operation := &operations[i]
+		if operation.skipped != "" {
+			continue
+		}
+		// the next operation is measured once the limit reached by the last one resets, in case they share it
+		if wait, throttled := resetDelay(last); throttled {
+			log.Printf("Waiting %s for the rate limit to reset", wait)
+			select {
+			case <-time.After(wait):
+			case <-abort:
+			}
+		}
+		select {
+		case <-abort:
+			operation.skipped = "aborted"
+			continue
+		default:
+		}
+		log.Printf("Measuring the rate limit of %s %s", operation.method, operation.path)
+		barrier := newStartBarrier(1)
+		go barrier.open()
+		target := probeTarget{client: client, method: operation.method, URL: baseURL + operation.path}
+		operation.result = measureRatelimit(target, token, profile, barrier, abort)
+		last = []measurement{operation.result}
+		audit.add(operation.operation.OperationID, target, operation.result)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OPERATION\tTAGS\tMETHOD\tPATH\tRATE (req/s)\tTHROTTLED")
+	for _, operation := range operations {
+		rate, throttled := "-", "-"
+		if operation.skipped != "" {
+			throttled = "skipped: " + operation.skipped
+		} else {
+			rate = fmt.Sprintf("%4.2f", operation.result.rate())
+			throttled = fmt.Sprintf("%t", operation.result.throttled)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", operation.operation.OperationID,
+			strings.Join(operation.operation.Tags, ","), operation.method, operation.path, rate, throttled)
+	}
+	w.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import "time"
+
+// pacer schedules the probes of a paced profile at the intended send times start + n*interval. Unlike a
+// time.Ticker, which drops the ticks while the workers are busy, the delayed probes are dispatched as soon as a
+// worker is available and keep their intended send time, so that the coordinated omission can be corrected
+type pacer struct {
+	start    time.Time
+	interval time.Duration
+	sent     int64
+	timer    *time.Timer
+}
+
+func newPacer(start time.Time, rate float64) *pacer {
+	p := &pacer{start: start, interval: time.Duration(float64(time.Second) / rate)}
+	p.timer = time.NewTimer(time.Until(start))
+	return p
+}
+
+// due returns the intended send time of the next probe
+func (p *pacer) due() time.Time {
+	return p.start.Add(time.Duration(p.sent) * p.interval)
+}
+
+// advance returns the intended send time of the probe being dispatched and schedules the next one
+func (p *pacer) advance() time.Time {
+	intended := p.due()
+	p.sent++
+	p.timer.Reset(time.Until(p.due()))
+	return intended
+}
+
+// setRate changes the rate of the probes scheduled from the next one on
+func (p *pacer) setRate(rate float64) {
+	p.start = p.due()
+	p.sent = 0
+	p.interval = time.Duration(float64(time.Second) / rate)
+	p.timer.Reset(time.Until(p.start))
+}
+
+func (p *pacer) stop() {
+	p.timer.Stop()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// typicalTokenSize is the assumed size of a bearer token when estimating the bytes sent by the probes
+const typicalTokenSize = 1500
+
+// planStage is a measurement the run is going to execute
+type planStage struct {
+	name     string
+	target   string
+	profiles []tokenProfile
+	// repeat multiplies the stage for the measurements repeated per throttled token, e.g. the failover
+	repeat int
+	// fixedRequests is the exact number of requests of a stage which does not run until throttled
+	fixedRequests uint64
+}
+
+// stageEstimate is the expected outcome of a stage, zero requests or duration mean unknown
+type stageEstimate struct {
+	requests uint64
+	duration time.Duration
+}
+
+// estimateProfile returns the expected requests and duration of a measurement with the given profile
+func estimateProfile(profile tokenProfile) stageEstimate {
+	if advertised.requests > 0 && (profile.rate == 0 || profile.rate > advertised.rate()) {
+		// the profile exhausts the advertised limit, overshooting by its in-flight requests
+		estimate := stageEstimate{
+			requests: uint64(advertised.requests) + uint64(profile.parallel),
+			duration: advertised.window,
+		}
+		if profile.rate > 0 {
+			estimate.duration = time.Duration(float64(estimate.requests) / profile.rate * float64(time.Second))
+		}
+		if profile.duration > 0 && profile.duration < estimate.duration {
+			estimate.requests = uint64(float64(estimate.requests) * float64(profile.duration) / float64(estimate.duration))
+			estimate.duration = profile.duration
+		}
+		return estimate
+	}
+	if profile.rate > 0 && profile.duration > 0 {
+		return stageEstimate{uint64(profile.rate * profile.duration.Seconds()), profile.duration}
+	}
+	return stageEstimate{duration: profile.duration}
+}
+
+// estimate returns the expected requests and duration of the stage, whose profiles run in parallel
+func (ps planStage) estimate() (stageEstimate, bool) {
+	if ps.fixedRequests > 0 {
+		return stageEstimate{requests: ps.fixedRequests}, true
+	}
+	var total stageEstimate
+	known := true
+	for _, profile := range ps.profiles {
+		estimate := estimateProfile(profile)
+		if estimate.requests == 0 {
+			known = false
+		}
+		total.requests += estimate.requests
+		if estimate.duration > total.duration {
+			total.duration = estimate.duration
+		}
+	}
+	repeat := uint64(ps.repeat)
+	if repeat == 0 {
+		repeat = 1
+	}
+	total.requests *= repeat
+	total.duration *= time.Duration(repeat)
+	return total, known
+}
+
+// planStages lists the measurements executed by the run configured with the flags
+func planStages() ([]planStage, error) {
+	if openAPISpec != "" {
+		operations, err := loadOpenAPIOperations(openAPISpec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the OpenAPI operations: %v", err)
+		}
+		profile := politeProfile
+		if measureDuration > 0 {
+			profile.duration = measureDuration
+		}
+		var stages []planStage
+		baseURL := strings.TrimSuffix(resource, "/")
+		for _, operation := range operations {
+			if operation.skipped != "" {
+				continue
+			}
+			stages = append(stages, planStage{
+				name:     operation.operation.OperationID,
+				target:   fmt.Sprintf("%s %s%s", operation.method, baseURL, operation.path),
+				profiles: []tokenProfile{profile},
+			})
+		}
+		return stages, nil
+	}
+
+	tokens := numTokens
+	// the roles and the products are compared with a single measurement
+	compare := roles != "" || len(apimKeys) > 0
+	if roles != "" {
+		tokens = len(strings.Split(roles, ","))
+	}
+	if len(apimKeys) > 0 {
+		tokens = len(apimKeys)
+	}
+	var tokenProfiles []tokenProfile
+	for i := 0; i < tokens; i++ {
+		tokenProfiles = append(tokenProfiles, profiles.profile(i))
+	}
+
+	methods := []string{probeMethod}
+	if sweepMethods && !compare {
+		// the supported methods are discovered at run time, plan for all the safe ones
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+
+	var stages []planStage
+	for _, method := range methods {
+		stages = append(stages, planStage{name: method, target: method + " " + params.apply(resource), profiles: tokenProfiles})
+		if compare {
+			break
+		}
+		if secondaryHost != "" {
+			secondary, err := failoverTarget(probeTarget{URL: params.apply(resource)}, secondaryHost)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse the secondary host: %v", err)
+			}
+			stages = append(stages, planStage{name: method + " failover", target: method + " " + secondary.URL, profiles: tokenProfiles})
+		}
+		if rotationTokens > 0 {
+			stages = append(stages, planStage{
+				name:          method + " token rotation",
+				target:        method + " " + params.apply(resource),
+				fixedRequests: uint64(tokens * rotationTokens * rotationProbes),
+			})
+		}
+		if metadataTest {
+			stages = append(stages, planStage{
+				name:          method + " metadata quota",
+				target:        http.MethodHead + " " + params.apply(resource),
+				fixedRequests: uint64(tokens * 2 * metadataProbes),
+			})
+		}
+		if compareConditional {
+			stages = append(stages, planStage{name: method + " conditional", target: method + " " + params.apply(resource), profiles: tokenProfiles})
+		}
+		if compareKeepAlive {
+			stages = append(stages, planStage{name: method + " fresh connections", target: method + " " + params.apply(resource), profiles: tokenProfiles})
+		}
+	}
+	return stages, nil
+}
+
+// typicalRequestSize estimates the bytes sent by a probe of the target
+func typicalRequestSize(target string) int64 {
+	parts := strings.SplitN(target, " ", 2)
+	req, err := http.NewRequest(parts[0], parts[1], nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.Repeat("x", typicalTokenSize))
+	return requestSize(req)
+}
+
+// formatEstimate formats an estimated quantity which may be unknown
+func formatEstimate(value interface{}, known bool) string {
+	if !known {
+		return "unknown"
+	}
+	return fmt.Sprint(value)
+}
+
+// printPlan prints what the measurement is going to do without sending any request
+func printPlan() error {
+	stages, err := planStages()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Resource: %s\n", resource)
+	fmt.Printf("Tokens: %d, parallel requests per token: %d\n", numTokens, parallelRequests)
+	if advertised.requests == 0 {
+		fmt.Println("No advertised limit, the stages running until the rate limit is reached have an unknown size")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STAGE\tTARGET\tREQUESTS\tDURATION\tBYTES SENT")
+	var requests uint64
+	var duration time.Duration
+	var sent int64
+	complete := true
+	for _, stage := range stages {
+		estimate, known := stage.estimate()
+		bytes := int64(estimate.requests) * typicalRequestSize(stage.target)
+		complete = complete && known && estimate.duration > 0
+		requests += estimate.requests
+		duration += estimate.duration
+		sent += bytes
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", stage.name, stage.target,
+			formatEstimate(estimate.requests, known),
+			formatEstimate(estimate.duration, estimate.duration > 0),
+			formatEstimate(bytes, known))
+	}
+	w.Flush()
+
+	qualifier := ""
+	if !complete {
+		qualifier = "at least "
+	}
+	fmt.Printf("Expected requests: %s%d, duration: %s%v\n", qualifier, requests, qualifier, duration)
+	fmt.Printf("Expected bytes sent: %s%d (%s%4.3f MB), the bytes received depend on the responses\n",
+		qualifier, sent, qualifier, float64(sent)/1e6)
+	if price > 0 {
+		fmt.Printf("Estimated cost: %s%.4f\n", qualifier, estimateCost(requests))
+	}
+	return nil
+}
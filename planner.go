@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	minBackoff        = 100 * time.Millisecond
+	backoffMultiplier = 2
+	backoffRetries    = 5
+)
+
+// planFleet prints the safe rate, burst and backoff parameters for each client of a fleet sharing the measured limit
+func planFleet(clients int, headroom float64, accepted uint64, elapsed time.Duration, retryAfter time.Duration) {
+	if clients < 1 {
+		return
+	}
+
+	budget := 1 - headroom/100
+	rate := float64(accepted) / elapsed.Seconds() * budget / float64(clients)
+	burst := int(math.Max(1, math.Floor(float64(accepted)*budget/float64(clients))))
+
+	initialBackoff, maxBackoff := backoff(retryAfter, rate)
+
+	log.Printf("Fleet budget for %d clients with %4.2f%% headroom:", clients, headroom)
+	log.Printf("  rate per client: %4.2f request/sec", rate)
+	log.Printf("  burst per client: %d requests", burst)
+	log.Printf("  backoff: initial %v, multiplier %d, max %v, %d retries", initialBackoff, backoffMultiplier, maxBackoff, backoffRetries)
+}
+
+// backoff returns the initial and the maximum exponential backoff delay, starting with the Retry-After delay when
+// known or else with the interval between two requests at the given rate
+func backoff(retryAfter time.Duration, rate float64) (time.Duration, time.Duration) {
+	initialBackoff := retryAfter
+	if initialBackoff <= 0 && rate > 0 {
+		initialBackoff = time.Duration(float64(time.Second) / rate)
+	}
+	if initialBackoff < minBackoff {
+		initialBackoff = minBackoff
+	}
+	return initialBackoff, initialBackoff * time.Duration(math.Pow(backoffMultiplier, backoffRetries))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenProfile configures the load generated with a single token
+type tokenProfile struct {
+	name     string
+	parallel int
+	// rate is the maximum number of requests per second, 0 means unlimited
+	rate float64
+	// duration is the maximum duration of the measurement, 0 means until the rate limit is reached
+	duration time.Duration
+}
+
+// tokenProfiles overrides the default profile per token index, e.g. 0:name=attacker,parallel=32,rate=100
+type tokenProfiles map[int]tokenProfile
+
+func (tp tokenProfiles) String() string {
+	var profiles []string
+	for index, profile := range tp {
+		profiles = append(profiles, fmt.Sprintf("%d:name=%s,parallel=%d,rate=%g,duration=%v",
+			index, profile.name, profile.parallel, profile.rate, profile.duration))
+	}
+	return strings.Join(profiles, " ")
+}
+
+func (tp tokenProfiles) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid profile %q, expected <token index>:<key>=<value>,...", value)
+	}
+	index, err := strconv.Atoi(parts[0])
+	if err != nil || index < 0 {
+		return fmt.Errorf("invalid token index %q", parts[0])
+	}
+
+	profile := tokenProfile{}
+	for _, setting := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid profile setting %q", setting)
+		}
+		switch kv[0] {
+		case "name":
+			profile.name = kv[1]
+		case "parallel":
+			profile.parallel, err = strconv.Atoi(kv[1])
+			if err != nil || profile.parallel < 1 {
+				return fmt.Errorf("invalid parallel requests %q", kv[1])
+			}
+		case "rate":
+			profile.rate, err = strconv.ParseFloat(kv[1], 64)
+			if err != nil || profile.rate < 0 {
+				return fmt.Errorf("invalid rate %q", kv[1])
+			}
+		case "duration":
+			profile.duration, err = time.ParseDuration(kv[1])
+			if err != nil || profile.duration < 0 {
+				return fmt.Errorf("invalid duration %q", kv[1])
+			}
+		default:
+			return fmt.Errorf("unknown profile setting %q", kv[0])
+		}
+	}
+	tp[index] = profile
+	return nil
+}
+
+// profile returns the profile of the token with the given index, falling back to the global settings
+func (tp tokenProfiles) profile(index int) tokenProfile {
+	profile := tp[index]
+	if profile.name == "" {
+		profile.name = fmt.Sprintf("token-%d", index)
+	}
+	if profile.parallel == 0 {
+		profile.parallel = parallelRequests
+	}
+	if profile.duration == 0 {
+		profile.duration = measureDuration
+	}
+	return profile
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// disableHTTP2 restricts the transport to HTTP/1.1, each probe in flight then needs its own connection
+func disableHTTP2(transport *http.Transport) {
+	transport.ForceAttemptHTTP2 = false
+	// a non-nil map disables the HTTP/2 upgrade of the TLS connections
+	transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+}
+
+// reportProtocols logs the number of responses per negotiated protocol
+func reportProtocols(name string, totals sampleTotals) {
+	if len(totals.protocols) == 0 {
+		return
+	}
+	var protocols []string
+	for proto, count := range totals.protocols {
+		protocols = append(protocols, fmt.Sprintf("%s %d", proto, count))
+	}
+	sort.Strings(protocols)
+	log.Printf("Protocols of %s: %s", name, strings.Join(protocols, ", "))
+}
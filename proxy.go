@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// workerKey is the context key of the index of the worker sending a probe
+type workerKey struct{}
+
+// withWorker returns the context of the probes sent by the worker
+func withWorker(ctx context.Context, worker int) context.Context {
+	return context.WithValue(ctx, workerKey{}, worker)
+}
+
+// loadProxies parses the comma separated proxy URLs and the ones of the file, one per line
+func loadProxies(list string, file string) ([]*url.URL, error) {
+	var specs []string
+	if list != "" {
+		specs = strings.Split(list, ",")
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				specs = append(specs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var proxies []*url.URL
+	for _, spec := range specs {
+		proxy, err := url.Parse(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", spec, err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy %q, expected an http, https or socks5 URL", spec)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// rotateProxies returns the proxy function of a transport which sends the probes of every worker through its own
+// proxy of the list, the other requests go through the first one
+func rotateProxies(proxies []*url.URL) func(req *http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		worker, _ := req.Context().Value(workerKey{}).(int)
+		return proxies[worker%len(proxies)], nil
+	}
+}
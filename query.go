@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// templateAction matches the placeholders of a template, which are kept unescaped in the URL
+var templateAction = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// queryParam is a parameter appended to the query of the probe URL
+type queryParam struct {
+	key   string
+	value string
+}
+
+// queryParams are appended to the probe URL, e.g. -param q=term -param nocache={{rand}}
+type queryParams []queryParam
+
+func (qp *queryParams) String() string {
+	var params []string
+	for _, param := range *qp {
+		params = append(params, param.key+"="+param.value)
+	}
+	return strings.Join(params, "&")
+}
+
+func (qp *queryParams) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid query parameter %q, expected <key>=<value>", value)
+	}
+	*qp = append(*qp, queryParam{key: parts[0], value: parts[1]})
+	return nil
+}
+
+// apply appends the parameters to the query of the URL, the placeholders are rendered for every request
+func (qp queryParams) apply(rawURL string) string {
+	if len(qp) == 0 {
+		return rawURL
+	}
+	var params []string
+	for _, param := range qp {
+		params = append(params, escapeQuery(param.key)+"="+escapeQuery(param.value))
+	}
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + strings.Join(params, "&")
+}
+
+// escapeQuery escapes the text outside of the template placeholders for the query of a URL
+func escapeQuery(text string) string {
+	var escaped strings.Builder
+	last := 0
+	for _, action := range templateAction.FindAllStringIndex(text, -1) {
+		escaped.WriteString(url.QueryEscape(text[last:action[0]]))
+		escaped.WriteString(text[action[0]:action[1]])
+		last = action[1]
+	}
+	escaped.WriteString(url.QueryEscape(text[last:]))
+	return escaped.String()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// redaction modes of the captured requests selected with -record-redact
+const (
+	// redactAll redacts every query value and the ID-like path segments
+	redactAll = "all"
+	// redactCredentials only redacts the values of the sensitiveQueryParameters
+	redactCredentials = "credentials"
+)
+
+var redactionModes = []string{redactAll, redactCredentials}
+
+// sensitiveQueryParameters are redacted from the captured requests since they may carry credentials
+var sensitiveQueryParameters = []string{"access_token", "api_key", "apikey", "code", "key", "sig", "signature", "subscription-key", "token"}
+
+// idSegment matches the path segments identifying a user or an entity: UUIDs, numbers and hex digests
+var idSegment = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9]+|[0-9a-fA-F]{16,})$`)
+
+// tokenSegment matches the long random tokens, which mix letters and digits
+var tokenSegment = regexp.MustCompile(`^[A-Za-z0-9_.~-]{20,}$`)
+
+// isIDSegment returns true for the path segments which look like an identifier rather than a route
+func isIDSegment(segment string) bool {
+	if idSegment.MatchString(segment) {
+		return true
+	}
+	return tokenSegment.MatchString(segment) && strings.ContainsAny(segment, "0123456789") &&
+		strings.IndexFunc(segment, unicode.IsLetter) >= 0
+}
+
+// anonymizedURI returns the request URI with the query values redacted according to the mode, and the ID-like path
+// segments too unless only the credentials are redacted
+func anonymizedURI(u *url.URL, mode string) string {
+	anonymized := *u
+	if mode == redactAll {
+		segments := strings.Split(u.EscapedPath(), "/")
+		for i, segment := range segments {
+			if isIDSegment(segment) {
+				segments[i] = "REDACTED"
+			}
+		}
+		anonymized.RawPath = ""
+		anonymized.Path, _ = url.PathUnescape(strings.Join(segments, "/"))
+	}
+	query := u.Query()
+	for name := range query {
+		if mode == redactAll || containsFold(sensitiveQueryParameters, name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	anonymized.RawQuery = query.Encode()
+	return anonymized.RequestURI()
+}
+
+// containsFold returns true when the values contain the value, ignoring the case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// capturedRequest is a request of the recorded traffic without any credential, header or body
+type capturedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Count  int    `json:"count"`
+}
+
+// trafficCapture is the mix of requests recorded by the proxy
+type trafficCapture struct {
+	Upstream string            `json:"upstream"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Skipped  int               `json:"skipped_unsafe"`
+	Requests []capturedRequest `json:"requests"`
+}
+
+// trafficRecorder counts the safe requests forwarded by the proxy, sampling them at the given percentage
+type trafficRecorder struct {
+	lock     sync.Mutex
+	sample   float64
+	redact   string
+	counts   map[capturedRequest]int
+	skipped  int
+	upstream string
+	start    time.Time
+}
+
+func (tr *trafficRecorder) record(req *http.Request) {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	if rand.Float64()*100 >= tr.sample {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		// the bodies are not captured and replaying writes is not safe
+		tr.skipped++
+		return
+	}
+	tr.counts[capturedRequest{Method: req.Method, Path: anonymizedURI(req.URL, tr.redact)}]++
+}
+
+// capture returns the recorded mix, the most frequent requests first
+func (tr *trafficRecorder) capture() trafficCapture {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	capture := trafficCapture{
+		Upstream: tr.upstream,
+		Start:    tr.start,
+		End:      time.Now().UTC(),
+		Skipped:  tr.skipped,
+	}
+	for request, count := range tr.counts {
+		request.Count = count
+		capture.Requests = append(capture.Requests, request)
+	}
+	sort.Slice(capture.Requests, func(i, j int) bool {
+		return capture.Requests[i].Count > capture.Requests[j].Count
+	})
+	return capture
+}
+
+// recordTraffic proxies the client traffic to the upstream until the duration elapses or the program is
+// interrupted, then writes the captured mix of requests
+func recordTraffic(listen string, upstream string, sample float64, redact string, duration time.Duration, path string) error {
+	upstreamURL, err := url.ParseRequestURI(upstream)
+	if err != nil {
+		return fmt.Errorf("failed to parse the upstream URL: %v", err)
+	}
+	recorder := &trafficRecorder{
+		sample:   sample,
+		redact:   redact,
+		counts:   make(map[capturedRequest]int),
+		upstream: upstream,
+		start:    time.Now().UTC(),
+	}
+	proxy := httputil.NewSingleHostReverseProxy(upstreamURL)
+	director := proxy.Director
+	// the request is recorded once rewritten, with the base path of the upstream which the replay needs
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = upstreamURL.Host
+		recorder.record(req)
+	}
+	server := &http.Server{Addr: listen, Handler: proxy}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	if duration > 0 {
+		time.AfterFunc(duration, func() { stop <- os.Interrupt })
+	}
+	go func() {
+		<-stop
+		server.Close()
+	}()
+
+	log.Printf("Recording the traffic proxied from %s to %s", listen, upstream)
+	err = server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	capture := recorder.capture()
+	data, err := json.MarshalIndent(capture, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		return err
+	}
+	log.Printf("Captured %d distinct requests in %s (%d unsafe requests skipped)", len(capture.Requests), path, capture.Skipped)
+	return nil
+}
+
+// requestMix replays a captured mix of requests in proportion to their frequency
+type requestMix struct {
+	requests []capturedRequest
+	sequence []int
+	next     uint64
+}
+
+// replay is the captured mix replacing the request of the probes, nil probes the resource itself
+var replay *requestMix
+
+func loadRequestMix(path string) (*requestMix, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var capture trafficCapture
+	err = json.Unmarshal(data, &capture)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the capture: %v", err)
+	}
+	mix := &requestMix{requests: capture.Requests}
+	for i, request := range capture.Requests {
+		for j := 0; j < request.Count; j++ {
+			mix.sequence = append(mix.sequence, i)
+		}
+	}
+	if len(mix.sequence) == 0 {
+		return nil, fmt.Errorf("no requests captured in %s", path)
+	}
+	rand.Shuffle(len(mix.sequence), func(i, j int) {
+		mix.sequence[i], mix.sequence[j] = mix.sequence[j], mix.sequence[i]
+	})
+	return mix, nil
+}
+
+// target returns the next captured request sent to the host of the probe target
+func (rm *requestMix) target(target probeTarget) probeTarget {
+	if rm == nil {
+		return target
+	}
+	index := atomic.AddUint64(&rm.next, 1) % uint64(len(rm.sequence))
+	request := rm.requests[rm.sequence[index]]
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return target
+	}
+	target.method = request.Method
+	target.URL = fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, request.Path)
+	return target
+}
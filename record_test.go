@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAnonymizedURI(t *testing.T) {
+	tests := []struct {
+		URL        string
+		mode       string
+		anonymized string
+	}{
+		{URL: "https://api.contoso.com/orders", mode: redactAll, anonymized: "/orders"},
+		{URL: "https://api.contoso.com/users/42/orders/7f1c2a9e-3b4d-4e5f-8a6b-9c0d1e2f3a4b", mode: redactAll,
+			anonymized: "/users/REDACTED/orders/REDACTED"},
+		{URL: "https://api.contoso.com/blobs/0123456789abcdef0123", mode: redactAll, anonymized: "/blobs/REDACTED"},
+		{URL: "https://api.contoso.com/sessions/a1b2c3d4e5f6g7h8i9j0k1", mode: redactAll, anonymized: "/sessions/REDACTED"},
+		{URL: "https://api.contoso.com/v1/search?q=term&page=2", mode: redactAll,
+			anonymized: "/v1/search?page=REDACTED&q=REDACTED"},
+		{URL: "https://api.contoso.com/users/42?sig=abc&sv=2020-08-04", mode: redactCredentials,
+			anonymized: "/users/42?sig=REDACTED&sv=2020-08-04"},
+		{URL: "https://api.contoso.com/callback?CODE=abc&state=xyz&Access_Token=t", mode: redactCredentials,
+			anonymized: "/callback?Access_Token=REDACTED&CODE=REDACTED&state=xyz"},
+		{URL: "https://api.contoso.com/v1/orders", mode: redactCredentials, anonymized: "/v1/orders"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if anonymized := anonymizedURI(u, test.mode); anonymized != test.anonymized {
+			t.Errorf("anonymizedURI(%s, %s) = %s, expected %s", test.URL, test.mode, anonymized, test.anonymized)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// redirectsKey is the context key of the number of redirects followed by a probe
+type redirectsKey struct{}
+
+// withRedirectCount returns the context of a request whose followed redirects are counted
+func withRedirectCount(ctx context.Context) (context.Context, *int) {
+	count := new(int)
+	return context.WithValue(ctx, redirectsKey{}, count), count
+}
+
+// checkRedirect follows up to -follow-redirects redirects to the hosts allowed by the safety config and counts them,
+// redirects are an error by default
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > followRedirects {
+		if followRedirects == 0 {
+			return fmt.Errorf("redirect to %s not allowed", req.URL)
+		}
+		return fmt.Errorf("stopped after %d redirects", followRedirects)
+	}
+	if redirectSafety != nil {
+		err := checkTargetSafety(redirectSafety, req.URL.String())
+		if err != nil {
+			return fmt.Errorf("redirect to %s refused: %v", req.URL, err)
+		}
+	}
+	// the client only drops the Authorization and Cookie headers on a redirect to another domain, not the keys and
+	// the signatures of the other authentications
+	if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		for _, name := range credentialHeaders() {
+			req.Header.Del(name)
+		}
+	}
+	if count, ok := req.Context().Value(redirectsKey{}).(*int); ok {
+		*count = len(via)
+	}
+	return nil
+}
+
+// reportRedirects logs the responses reached through redirects with the number of redirect chains per final location,
+// some APIs redirect the throttled requests to an error page instead of answering a 429
+func reportRedirects(name string, totals sampleTotals) {
+	if totals.redirected == 0 {
+		return
+	}
+	var locations []string
+	for location, count := range totals.chains {
+		locations = append(locations, fmt.Sprintf("%s (%d)", location, count))
+	}
+	sort.Strings(locations)
+	log.Printf("Redirects of %s: %d of %d responses after a redirect chain, up to %d redirects, final responses: %s",
+		name, totals.redirected, totals.count, totals.maxRedirects, strings.Join(locations, ", "))
+}
+
+// redirectLocation returns the final URL of a redirected response, without its query
+func redirectLocation(resp *probeResponse) string {
+	if resp.redirects == 0 || resp.Request == nil {
+		return ""
+	}
+	location := *resp.Request.URL
+	location.RawQuery = ""
+	return location.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ccojocar/arl/tokensource"
+)
+
+// authConfig are the settings of a custom token source, e.g. -auth-config endpoint=https://sts.example.com
+type authConfig map[string]string
+
+func (ac authConfig) String() string {
+	var keys []string
+	for key := range ac {
+		// never print the values, which are usually secrets
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (ac authConfig) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid setting %q, expected <key>=<value>", value)
+	}
+	ac[parts[0]] = parts[1]
+	return nil
+}
+
+// newCustomTokenSource creates the token source registered in the tokensource package for the authentication mode
+func newCustomTokenSource(name string, settings authConfig) (TokenSource, error) {
+	factory, ok := tokensource.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown auth mode %q", name)
+	}
+	cfg := map[string]string{
+		"resource":  resource,
+		"tenant_id": tenantID,
+		"client_id": clientID,
+		"scopes":    scopes,
+	}
+	if audience, err := resourceAudience(); err == nil {
+		cfg["audience"] = audience
+	}
+	for key, value := range settings {
+		cfg[key] = value
+	}
+	tokenSource, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s token source: %v", name, err)
+	}
+	return tokenSource, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// resolveMappings map a host and port to the IP the probes connect to, e.g. -resolve api.contoso.com:443:10.0.1.17
+type resolveMappings map[string]string
+
+func (rm resolveMappings) String() string {
+	var mappings []string
+	for addr, ip := range rm {
+		mappings = append(mappings, addr+":"+ip)
+	}
+	return strings.Join(mappings, ",")
+}
+
+func (rm resolveMappings) Set(value string) error {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return fmt.Errorf("invalid mapping %q, expected <host>:<port>:<ip>", value)
+	}
+	if port, err := strconv.Atoi(parts[1]); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port in the mapping %q", value)
+	}
+	ip := net.ParseIP(strings.Trim(parts[2], "[]"))
+	if ip == nil {
+		return fmt.Errorf("invalid IP in the mapping %q", value)
+	}
+	rm[net.JoinHostPort(strings.ToLower(parts[0]), parts[1])] = ip.String()
+	return nil
+}
+
+// dialer returns the dial function of a transport connecting the mapped addresses to their IP, the other ones are
+// dialed unchanged
+func (rm resolveMappings) dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := rm[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestResolveMappingsSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		addr    string
+		ip      string
+		wantErr bool
+	}{
+		{value: "api.contoso.com:443:10.0.1.17", addr: "api.contoso.com:443", ip: "10.0.1.17"},
+		{value: "API.Contoso.com:8443:10.0.1.17", addr: "api.contoso.com:8443", ip: "10.0.1.17"},
+		{value: "api.contoso.com:443:[2001:db8::1]", addr: "api.contoso.com:443", ip: "2001:db8::1"},
+		{value: "api.contoso.com:443:2001:db8::1", addr: "api.contoso.com:443", ip: "2001:db8::1"},
+		{value: "api.contoso.com:443", wantErr: true},
+		{value: ":443:10.0.1.17", wantErr: true},
+		{value: "api.contoso.com:https:10.0.1.17", wantErr: true},
+		{value: "api.contoso.com:0:10.0.1.17", wantErr: true},
+		{value: "api.contoso.com:65536:10.0.1.17", wantErr: true},
+		{value: "api.contoso.com:443:backend", wantErr: true},
+	}
+	for _, test := range tests {
+		mappings := resolveMappings{}
+		err := mappings.Set(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) = %v, expected an error", test.value, mappings)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q) failed: %v", test.value, err)
+			continue
+		}
+		if ip := mappings[test.addr]; len(mappings) != 1 || ip != test.ip {
+			t.Errorf("Set(%q) = %v, expected %s mapped to %s", test.value, mappings, test.addr, test.ip)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// tierTolerance is the relative difference between the rates of two roles (or products) still considered as the same tier
+const tierTolerance = 0.2
+
+// abortOnInterrupt returns a channel closed when the program is interrupted
+func abortOnInterrupt(interrupt chan os.Signal) chan struct{} {
+	abort := make(chan struct{})
+	go func() {
+		<-interrupt
+		log.Println("Waiting for rate limit probes to complete...")
+		close(abort)
+	}()
+	return abort
+}
+
+// acquireRoleTokens signs in once for every role, each with the identity granted that role
+func acquireRoleTokens(roles []string) ([]string, error) {
+	var tokens []string
+	for _, role := range roles {
+		tokenSource, err := newAzureTokenSource()
+		if err != nil {
+			return nil, err
+		}
+		// every role is a different identity, the cached refresh token belongs to a single one
+		tokenSource.cache = nil
+		log.Printf("Sign in with the %s identity", role)
+		token, err := tokenSource.Login()
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign in with the %s identity: %v", role, err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// compareTiers runs the same measurement for the credential of each role (or product) and reports whether the
+// API grants different throttling tiers by kind
+func compareTiers(target probeTarget, kind string, roles []string, pool *tokenPool, interrupt chan os.Signal) {
+	abort := abortOnInterrupt(interrupt)
+	results := make([]measurement, len(roles))
+	for i, role := range roles {
+		select {
+		case <-abort:
+			return
+		default:
+		}
+		profile := profiles.profile(i)
+		profile.name = role
+		token, err := pool.get(i)
+		if err != nil {
+			log.Printf("failed to get the token of %s: %v", role, err)
+			continue
+		}
+		barrier := newStartBarrier(1)
+		go barrier.open()
+		log.Printf("Measuring the rate limit of %s %s as %s", target.method, target.URL, role)
+		results[i] = measureRatelimit(target, token, profile, barrier, abort)
+		audit.add(role, target, results[i])
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tACCEPTED\tRATE (req/s)\tTHROTTLED\n", strings.ToUpper(kind))
+	for i, role := range roles {
+		fmt.Fprintf(w, "%s\t%d\t%4.2f\t%t\n", role, results[i].accepted, results[i].rate(), results[i].throttled)
+	}
+	w.Flush()
+
+	if tiers := throttlingTiers(roles, results); tiers != "" {
+		log.Printf("The API grants different throttling tiers by %s: %s", kind, tiers)
+	} else {
+		log.Printf("The API grants the same throttling tier to all %ss", kind)
+	}
+}
+
+// throttlingTiers describes how the roles differ, empty when they all got the same tier
+func throttlingTiers(roles []string, results []measurement) string {
+	var throttled, unthrottled []string
+	lowest, highest := -1.0, 0.0
+	for i, result := range results {
+		if !result.throttled {
+			unthrottled = append(unthrottled, roles[i])
+			continue
+		}
+		throttled = append(throttled, roles[i])
+		if lowest < 0 || result.rate() < lowest {
+			lowest = result.rate()
+		}
+		if result.rate() > highest {
+			highest = result.rate()
+		}
+	}
+	if len(throttled) > 0 && len(unthrottled) > 0 {
+		return fmt.Sprintf("%s throttled while %s did not", strings.Join(throttled, ", "), strings.Join(unthrottled, ", "))
+	}
+	if lowest > 0 && highest > lowest*(1+tierTolerance) {
+		return fmt.Sprintf("limits range from %4.2f to %4.2f request/sec", lowest, highest)
+	}
+	return ""
+}
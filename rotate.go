@@ -0,0 +1,145 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a file which is rotated once it exceeds its maximum size or age, the rotated files are
+// renamed with a timestamp suffix and optionally compressed
+type rotatingFile struct {
+	lock     sync.Mutex
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	file     *os.File
+	size     int64
+	opened   time.Time
+}
+
+// openRotatingFile opens the file for appending, a zero maximum size or age disables the respective rotation
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, compress bool) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		compress: compress,
+	}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(rf.path), 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.size > 0 && rf.due(int64(len(p))) {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing the given number of bytes requires a rotation first
+func (rf *rotatingFile) due(n int64) bool {
+	if rf.maxSize > 0 && rf.size+n > rf.maxSize {
+		return true
+	}
+	return rf.maxAge > 0 && time.Since(rf.opened) > rf.maxAge
+}
+
+func (rf *rotatingFile) rotate() error {
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", rf.path, time.Now().UTC().Format("20060102T150405.000"))
+	err = os.Rename(rf.path, rotated)
+	if err != nil {
+		return err
+	}
+	if rf.compress {
+		// compressing may take a while, the writers carry on with the new file meanwhile
+		go func() {
+			err := compressFile(rotated)
+			if err != nil {
+				log.Printf("failed to compress the rotated file %s: %v", rotated, err)
+			}
+		}()
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// compressFile replaces the file with its gzip compressed copy
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// rotationProbes is the number of probes sent with each fresh token during the rotation test
+const rotationProbes = 5
+
+// testTokenRotation checks whether switching to fresh tokens of the same principal resets a reached rate limit
+func testTokenRotation(tokenSource TokenSource, target probeTarget, numTokens int, abort chan struct{}) {
+	var accepted, throttled int
+	for i := 0; i < numTokens; i++ {
+		token, err := tokenSource.Refresh()
+		if err != nil {
+			log.Printf("failed to acquire a fresh token for the rotation test: %v", err)
+			return
+		}
+		for j := 0; j < rotationProbes; j++ {
+			select {
+			case <-abort:
+				log.Println("Aborting the token rotation test")
+				return
+			default:
+			}
+			err := ceiling.wait(context.Background())
+			if err != nil {
+				return
+			}
+			resp, err := send(context.Background(), target, token)
+			if err != nil {
+				log.Printf("failed to execute the token rotation probe: %v", err)
+				return
+			}
+			if resp.accepted {
+				accepted++
+			} else if isThrottled(resp.Response) {
+				throttled++
+			}
+		}
+	}
+
+	if accepted > 0 {
+		log.Printf("Token rotation bypasses the rate limit: %d of %d probes with fresh tokens were accepted",
+			accepted, numTokens*rotationProbes)
+	} else {
+		log.Printf("Token rotation does not bypass the rate limit: %d of %d probes with fresh tokens were throttled",
+			throttled, numTokens*rotationProbes)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// redirectSafety is the safety config the redirects followed by the probes are checked against, nil with
+// -i-know-what-i-am-doing
+var redirectSafety *safetyConfig
+
+// safetyConfig lists the host patterns (e.g. *.prod.example.com) which may or may not be measured
+type safetyConfig struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// defaultSafetyConfigPath returns the location of the safety config in the home directory of the user
+func defaultSafetyConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".arl", "safety.json")
+}
+
+// loadSafetyConfig reads the safety config, a missing file means no restrictions
+func loadSafetyConfig(path string) (*safetyConfig, error) {
+	config := &safetyConfig{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the safety config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error when the host is denied or not allowed
+func (sc *safetyConfig) check(host string) error {
+	host = strings.ToLower(host)
+	if matchesHost(sc.Deny, host) {
+		return fmt.Errorf("host %s is denied by the safety config", host)
+	}
+	if len(sc.Allow) > 0 && !matchesHost(sc.Allow, host) {
+		return fmt.Errorf("host %s is not allowed by the safety config", host)
+	}
+	return nil
+}
+
+// checkTargetSafety verifies every host the measurement is going to send requests to, i.e. the hosts of the URLs
+// and the addresses actually dialed or sent in the Host header and the TLS server name
+func checkTargetSafety(config *safetyConfig, URLs ...string) error {
+	for _, URL := range URLs {
+		if strings.Contains(urlAuthority(URL), "{{") {
+			return fmt.Errorf("the host of %s is a template which can't be verified by the safety config", URL)
+		}
+		u, err := url.Parse(URL)
+		if err != nil {
+			return err
+		}
+		for _, host := range contactedHosts(u) {
+			err = config.check(host)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// urlAuthority returns the user info, host and port part of a URL
+func urlAuthority(URL string) string {
+	if i := strings.Index(URL, "://"); i >= 0 {
+		URL = URL[i+3:]
+	}
+	if i := strings.IndexAny(URL, "/?#"); i >= 0 {
+		URL = URL[:i]
+	}
+	return URL
+}
+
+// contactedHosts returns the host of the URL along with the IP of its -resolve mapping, the -unix-socket, the
+// -host-header and the -sni the probes send instead
+func contactedHosts(u *url.URL) []string {
+	hosts := []string{u.Hostname()}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	if ip, ok := resolve[net.JoinHostPort(strings.ToLower(u.Hostname()), port)]; ok {
+		hosts = append(hosts, ip)
+	}
+	if unixSocket != "" {
+		hosts = append(hosts, unixSocket)
+	}
+	if hostHeader != "" {
+		host := hostHeader
+		if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+			host = h
+		}
+		hosts = append(hosts, host)
+	}
+	if serverName != "" {
+		hosts = append(hosts, serverName)
+	}
+	return hosts
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// sampleEntry is a sample as written to the NDJSON sample log
+type sampleEntry struct {
+	Token         string     `json:"token"`
+	Intended      *time.Time `json:"intended,omitempty"`
+	Start         time.Time  `json:"start"`
+	LatencyMs     float64    `json:"latency_ms"`
+	ServerMs      float64    `json:"server_ms,omitempty"`
+	Status        int        `json:"status"`
+	RequestBytes  int64      `json:"request_bytes"`
+	ResponseBytes int64      `json:"response_bytes"`
+	Protocol      string     `json:"protocol,omitempty"`
+	// DecompressedBytes is the size of a compressed response body once decompressed
+	DecompressedBytes int64 `json:"decompressed_bytes,omitempty"`
+	// Redirects is the number of redirects followed to the final Location of the response
+	Redirects int    `json:"redirects,omitempty"`
+	Location  string `json:"location,omitempty"`
+	// RequestID is the correlation ID sent with the request
+	RequestID string `json:"request_id,omitempty"`
+	SourceIP  string `json:"source_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Body is the captured beginning of the response body
+	Body string `json:"body,omitempty"`
+}
+
+// sampleLog writes every sample as a JSON line
+type sampleLog struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	failed  bool
+}
+
+func newSampleLog(w io.Writer) *sampleLog {
+	return &sampleLog{encoder: json.NewEncoder(w)}
+}
+
+// write appends the sample to the log, it does nothing when the sample log is disabled
+func (sl *sampleLog) write(name string, s sample) {
+	if sl == nil {
+		return
+	}
+	entry := sampleEntry{
+		Token:         name,
+		Start:         s.start.UTC(),
+		LatencyMs:     float64(s.latency) / float64(time.Millisecond),
+		ServerMs:      float64(s.server) / float64(time.Millisecond),
+		Status:        s.status,
+		RequestBytes:  s.requestBytes,
+		ResponseBytes: s.responseBytes,
+		Protocol:      s.proto,
+		Redirects:     s.redirects,
+		Location:      s.location,
+		RequestID:     s.requestID,
+		SourceIP:      s.source,
+		UserAgent:     s.userAgent,
+		Body:          string(s.body),
+	}
+	if s.decompressedBytes != s.bodyBytes {
+		entry.DecompressedBytes = s.decompressedBytes
+	}
+	if !s.intended.IsZero() {
+		intended := s.intended.UTC()
+		entry.Intended = &intended
+	}
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	err := sl.encoder.Encode(entry)
+	if err != nil && !sl.failed {
+		sl.failed = true
+		log.Printf("failed to write to the sample log: %v", err)
+	}
+}
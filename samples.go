@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sample is the outcome of a single probe
+type sample struct {
+	// intended is the scheduled send time of a paced probe, zero for the closed-loop probes
+	intended time.Time
+	start    time.Time
+	latency  time.Duration
+	// server is the processing time advertised by the server, zero when unknown
+	server time.Duration
+	status int
+	// accepted is true when the status is one of the accepted codes of the probed target
+	accepted bool
+	// requestBytes and responseBytes are the sizes of the exchanged messages on the wire
+	requestBytes  int64
+	responseBytes int64
+	// bodyBytes is the size of the response body on the wire and decompressedBytes its size once decompressed
+	bodyBytes         int64
+	decompressedBytes int64
+	// redirects is the number of redirects followed to the final location of the response
+	redirects int
+	location  string
+	// requestID is the correlation ID of the probe
+	requestID string
+	// source is the local IP the probe was sent from, empty unless bound with -source-ip
+	source string
+	// userAgent is the User-Agent of the probe, empty for the default one
+	userAgent string
+	// body is the beginning of the response body captured for the sample log, it is not kept in memory
+	body []byte
+	// proto is the negotiated protocol, e.g. HTTP/1.1 or HTTP/2.0
+	proto string
+}
+
+// sampleTotals are the aggregates of all the samples of a measurement, the reports of the counts and bytes do not
+// need the samples themselves
+type sampleTotals struct {
+	count int64
+	// requestBytes and responseBytes are the sizes of the exchanged messages on the wire
+	requestBytes  int64
+	responseBytes int64
+	// bodyBytes is the size of the response bodies on the wire and decompressedBytes their size once decompressed
+	bodyBytes         int64
+	decompressedBytes int64
+	// protocols counts the responses per negotiated protocol
+	protocols map[string]int
+	// redirected counts the responses reached through a redirect chain, per final status and location in chains
+	redirected   int
+	maxRedirects int
+	chains       map[string]int
+	// sources and userAgents count the probes per source IP and User-Agent rotated across the workers
+	sources    map[string]*rotatedCounts
+	userAgents map[string]*rotatedCounts
+}
+
+// rotatedCounts are the outcomes of the probes sent with a value rotated across the workers
+type rotatedCounts struct{ sent, accepted, throttled int }
+
+func (rc *rotatedCounts) add(s sample) {
+	rc.sent++
+	if s.accepted {
+		rc.accepted++
+	} else if s.status == http.StatusTooManyRequests {
+		rc.throttled++
+	}
+}
+
+func newSampleTotals() sampleTotals {
+	return sampleTotals{
+		protocols:  make(map[string]int),
+		chains:     make(map[string]int),
+		sources:    make(map[string]*rotatedCounts),
+		userAgents: make(map[string]*rotatedCounts),
+	}
+}
+
+func (st *sampleTotals) add(s sample) {
+	st.count++
+	st.requestBytes += s.requestBytes
+	st.responseBytes += s.responseBytes
+	st.bodyBytes += s.bodyBytes
+	st.decompressedBytes += s.decompressedBytes
+	if s.proto != "" {
+		st.protocols[s.proto]++
+	}
+	if s.redirects > 0 {
+		st.redirected++
+		st.chains[fmt.Sprintf("%d %s", s.status, s.location)]++
+		if s.redirects > st.maxRedirects {
+			st.maxRedirects = s.redirects
+		}
+	}
+	countRotated(st.sources, s.source, s)
+	countRotated(st.userAgents, s.userAgent, s)
+}
+
+func countRotated(counts map[string]*rotatedCounts, value string, s sample) {
+	if value == "" {
+		return
+	}
+	c, ok := counts[value]
+	if !ok {
+		c = &rotatedCounts{}
+		counts[value] = c
+	}
+	c.add(s)
+}
+
+// sampleRecorder appends the samples of a measurement to the sample log and keeps their totals, along with a
+// random subset of at most -max-samples samples for the latency percentiles and the trace, so that the memory stays
+// bounded during long runs
+type sampleRecorder struct {
+	lock      sync.Mutex
+	name      string
+	log       *sampleLog
+	totals    sampleTotals
+	reservoir []sample
+	// latencies are the counts of the heatmap, nil without -heatmap
+	latencies *latencyCounts
+}
+
+func newSampleRecorder(name string, log *sampleLog) *sampleRecorder {
+	sr := &sampleRecorder{name: name, log: log, totals: newSampleTotals()}
+	if heatmapFile != "" {
+		sr.latencies = &latencyCounts{}
+	}
+	return sr
+}
+
+// begin sets the start of the measurement, the heatmap counts the samples per second since then
+func (sr *sampleRecorder) begin(start time.Time) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	if sr.latencies != nil {
+		sr.latencies.start = start
+	}
+}
+
+func (sr *sampleRecorder) record(s sample) {
+	sr.log.write(sr.name, s)
+	s.body = nil
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.totals.add(s)
+	if sr.latencies != nil {
+		sr.latencies.add(s)
+	}
+	// reservoir sampling keeps every sample with the same probability
+	if len(sr.reservoir) < maxSamples {
+		sr.reservoir = append(sr.reservoir, s)
+	} else if i := rand.Int63n(sr.totals.count); i < int64(maxSamples) {
+		sr.reservoir[i] = s
+	}
+}
+
+// snapshot returns a copy of the samples kept so far and the totals of all the samples
+func (sr *sampleRecorder) snapshot() ([]sample, sampleTotals) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	return append([]sample(nil), sr.reservoir...), sr.totals
+}
+
+// heatmap returns a copy of the heatmap counts of all the samples so far
+func (sr *sampleRecorder) heatmap() *latencyCounts {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	return &latencyCounts{start: sr.latencies.start, seconds: append([][heatmapRows]int(nil), sr.latencies.seconds...)}
+}
+
+// reportRotated logs the accepted and throttled probes per value rotated across the workers, e.g. the source IPs
+func reportRotated(kind string, name string, values []string, counts map[string]*rotatedCounts) {
+	for _, v := range values {
+		if c, ok := counts[v]; ok {
+			log.Printf("%s %s of %s: %d probes, %d accepted, %d throttled", kind, v, name, c.sent, c.accepted, c.throttled)
+		}
+	}
+}
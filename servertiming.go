@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationHeaders lists the headers carrying the server processing time in milliseconds
+var durationHeaders = []string{"x-ms-request-duration", "x-ms-request-duration-ms", "X-Response-Time"}
+
+func parseMilliseconds(value string) (time.Duration, bool) {
+	value = strings.TrimSuffix(strings.TrimSpace(value), "ms")
+	ms, err := strconv.ParseFloat(value, 64)
+	if err != nil || ms < 0 {
+		return 0, false
+	}
+	return time.Duration(ms * float64(time.Millisecond)), true
+}
+
+// serverTiming returns the server processing time advertised by the response. The Server-Timing metric
+// named total is preferred over the sum of all metrics.
+func serverTiming(header http.Header) time.Duration {
+	var sum time.Duration
+	for _, value := range header["Server-Timing"] {
+		for _, metric := range strings.Split(value, ",") {
+			params := strings.Split(metric, ";")
+			name := strings.TrimSpace(params[0])
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || kv[0] != "dur" {
+					continue
+				}
+				dur, ok := parseMilliseconds(strings.Trim(kv[1], `"`))
+				if !ok {
+					continue
+				}
+				if name == "total" {
+					return dur
+				}
+				sum += dur
+			}
+		}
+	}
+	if sum > 0 {
+		return sum
+	}
+	for _, name := range durationHeaders {
+		if dur, ok := parseMilliseconds(header.Get(name)); ok {
+			return dur
+		}
+	}
+	return 0
+}
+
+// reportServerTiming logs the server processing time separately from the network time
+func reportServerTiming(name string, samples []sample) {
+	var server, network []time.Duration
+	for _, s := range samples {
+		if s.server <= 0 {
+			continue
+		}
+		server = append(server, s.server)
+		network = append(network, s.latency-s.server)
+	}
+	if len(server) == 0 {
+		return
+	}
+	log.Printf("Server time of %s (%d of %d responses): %s", name, len(server), len(samples), formatPercentiles(server))
+	log.Printf("Network time of %s: %s", name, formatPercentiles(network))
+}
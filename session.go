@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// loginDataEnv is the environment variable holding the login form when the flag is not set
+const loginDataEnv = "ARL_LOGIN_DATA"
+
+// sessionJars are the cookie jars of the sessions by their token, seeded by the login and updated with the cookies
+// rotated by the resource with Set-Cookie during the measurement
+var sessionJars sync.Map
+
+// sessionClient returns the client of the probes of the token, with the cookie jar of its session
+func sessionClient(client *http.Client, token string) *http.Client {
+	jar, ok := sessionJars.Load(token)
+	if !ok {
+		return client
+	}
+	session := *client
+	session.Jar = jar.(http.CookieJar)
+	return &session
+}
+
+// SessionTokenSource logs in to a legacy API with a form or a JSON document and returns its session cookies, every
+// token is a distinct session
+type SessionTokenSource struct {
+	loginURL string
+	data     string
+	resource string
+}
+
+// NewSessionTokenSource creates a token source posting the login data to the login URL
+func NewSessionTokenSource(loginURL string, data string, resource string) (*SessionTokenSource, error) {
+	if loginURL == "" || data == "" {
+		return nil, fmt.Errorf("the -login-url and -login-data are required by the %s authentication", authSession)
+	}
+	return &SessionTokenSource{
+		loginURL: loginURL,
+		data:     data,
+		resource: resource,
+	}, nil
+}
+
+// Token logs in and returns the session cookies sent to the resource, as the value of a Cookie header, the probes
+// send the cookies of the session jar instead so that the cookies rotated by the resource are kept
+func (ts *SessionTokenSource) Token() (string, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", err
+	}
+	client := newProbeClient(true, true)
+	client.Jar = jar
+	// the login forms usually redirect once the session is created
+	client.CheckRedirect = nil
+	req, err := http.NewRequest(http.MethodPost, ts.loginURL, strings.NewReader(ts.data))
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(strings.TrimSpace(ts.data), "{") {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("failed to log in: %s", resp.Status)
+	}
+
+	resourceURL, err := url.Parse(ts.resource)
+	if err != nil {
+		return "", err
+	}
+	var cookies []string
+	for _, cookie := range jar.Cookies(resourceURL) {
+		cookies = append(cookies, cookie.String())
+	}
+	if len(cookies) == 0 {
+		return "", errors.New("the login did not set any session cookie for the resource")
+	}
+	token := strings.Join(cookies, "; ")
+	sessionJars.Store(token, jar)
+	return token, nil
+}
+
+// Refresh logs in again for a new session
+func (ts *SessionTokenSource) Refresh() (string, error) {
+	return ts.Token()
+}
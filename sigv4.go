@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sigV4Signer signs the probe requests with the AWS Signature Version 4 instead of sending a bearer token
+type sigV4Signer struct {
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	service     string
+	region      string
+}
+
+// newSigV4Signer loads the credentials of the standard AWS chain (environment, shared config and credentials
+// files, SSO, web identity, container and instance roles), the service and region are inferred from the host of
+// the resource when empty
+func newSigV4Signer(resource string, service string, region string) (*sigV4Signer, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS configuration: %v", err)
+	}
+	hostService, hostRegion := awsEndpoint(resource)
+	service = firstNonEmpty(service, hostService)
+	region = firstNonEmpty(region, hostRegion, cfg.Region)
+	if service == "" || region == "" {
+		return nil, fmt.Errorf("cannot infer the AWS service and region of %s, set -aws-service and -aws-region", resource)
+	}
+	return &sigV4Signer{
+		credentials: aws.NewCredentialsCache(cfg.Credentials),
+		signer:      v4.NewSigner(),
+		service:     service,
+		region:      region,
+	}, nil
+}
+
+// awsEndpoint returns the service and the region of an AWS endpoint such as
+// <api>.execute-api.<region>.amazonaws.com or <bucket>.s3.<region>.amazonaws.com
+func awsEndpoint(resource string) (string, string) {
+	resourceURL, err := url.Parse(resource)
+	if err != nil {
+		return "", ""
+	}
+	labels := strings.Split(resourceURL.Hostname(), ".")
+	for i := len(labels) - 1; i >= 2; i-- {
+		if labels[i] == "amazonaws" {
+			return labels[i-2], labels[i-1]
+		}
+	}
+	return "", ""
+}
+
+// accessKeyID returns the access key ID of the signing credentials, which identifies the AWS identity
+func (s *sigV4Signer) accessKeyID() (string, error) {
+	credentials, err := s.credentials.Retrieve(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve the AWS credentials: %v", err)
+	}
+	return credentials.AccessKeyID, nil
+}
+
+// sign signs the request with the current AWS credentials
+func (s *sigV4Signer) sign(req *http.Request) error {
+	credentials, err := s.credentials.Retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to retrieve the AWS credentials: %v", err)
+	}
+	payloadHash := emptyPayloadHash
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	// S3 requires the payload hash as a header as well
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	return s.signer.SignHTTP(req.Context(), credentials, req, payloadHash, s.service, s.region, time.Now())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var windowUnits = map[string]time.Duration{
+	"s":    time.Second,
+	"sec":  time.Second,
+	"m":    time.Minute,
+	"min":  time.Minute,
+	"h":    time.Hour,
+	"hour": time.Hour,
+	"d":    24 * time.Hour,
+	"day":  24 * time.Hour,
+}
+
+// advertisedLimit is a documented rate limit such as 1000/min
+type advertisedLimit struct {
+	text     string
+	requests int64
+	window   time.Duration
+}
+
+func (al *advertisedLimit) String() string {
+	return al.text
+}
+
+func (al *advertisedLimit) Set(value string) error {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid limit %q, expected <requests>/<unit>", value)
+	}
+	requests, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || requests < 1 {
+		return fmt.Errorf("invalid number of requests %q", parts[0])
+	}
+	window, ok := windowUnits[parts[1]]
+	if !ok {
+		window, err = time.ParseDuration(parts[1])
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid limit window %q", parts[1])
+		}
+	}
+	al.text = value
+	al.requests = requests
+	al.window = window
+	return nil
+}
+
+// rate returns the advertised limit in requests per second
+func (al *advertisedLimit) rate() float64 {
+	return float64(al.requests) / al.window.Seconds()
+}
+
+// allowed returns the number of requests the advertised limit permits within the elapsed time
+func (al *advertisedLimit) allowed(elapsed time.Duration) int64 {
+	if elapsed <= al.window {
+		return al.requests
+	}
+	return int64(float64(al.requests) * elapsed.Seconds() / al.window.Seconds())
+}
+
+// verifySLA reports whether the measured limit delivers at least the advertised one
+func verifySLA(limit *advertisedLimit, accepted uint64, elapsed time.Duration, throttled bool) {
+	if limit.requests == 0 {
+		return
+	}
+
+	allowed := limit.allowed(elapsed)
+	measuredRate := float64(accepted) / elapsed.Seconds()
+	log.Printf("Advertised limit %s (%4.2f request/sec), measured %4.2f request/sec", limit, limit.rate(), measuredRate)
+
+	if !throttled {
+		if int64(accepted) >= allowed {
+			log.Printf("SLA met: %d requests accepted without throttling (advertised %d)", accepted, allowed)
+		} else {
+			log.Printf("SLA inconclusive: measurement stopped after %d of %d advertised requests", accepted, allowed)
+		}
+		return
+	}
+
+	difference := int64(accepted) - allowed
+	percentage := 100 * float64(difference) / float64(allowed)
+	if difference < 0 {
+		log.Printf("SLA violated: throttling began %d requests (%4.2f%%) before the advertised threshold", -difference, -percentage)
+	} else {
+		log.Printf("SLA met: throttling began %d requests (%4.2f%%) after the advertised threshold", difference, percentage)
+	}
+}
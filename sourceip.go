@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sourceAddresses are the local IPs the probe connections are bound to, rotated across the workers
+type sourceAddresses []net.IP
+
+func (sa *sourceAddresses) String() string {
+	var ips []string
+	for _, ip := range *sa {
+		ips = append(ips, ip.String())
+	}
+	return strings.Join(ips, ",")
+}
+
+func (sa *sourceAddresses) Set(value string) error {
+	for _, address := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			return fmt.Errorf("invalid source IP %q", address)
+		}
+		*sa = append(*sa, ip)
+	}
+	return nil
+}
+
+// worker returns the source IP of the probes of the worker
+func (sa sourceAddresses) worker(worker int) string {
+	if len(sa) == 0 {
+		return ""
+	}
+	return sa[worker%len(sa)].String()
+}
+
+// sourceRoundTripper sends the probes of every worker through the transport bound to its source IP, the connections
+// of a transport are never reused from another IP
+type sourceRoundTripper []http.RoundTripper
+
+func (srt sourceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	worker, _ := req.Context().Value(workerKey{}).(int)
+	return srt[worker%len(srt)].RoundTrip(req)
+}
+
+// bindSourceIPs returns a round tripper with a copy of the transport for each source IP
+func bindSourceIPs(transport *http.Transport, ips sourceAddresses) sourceRoundTripper {
+	var roundTrippers sourceRoundTripper
+	for _, ip := range ips {
+		bound := transport.Clone()
+		dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second, LocalAddr: &net.TCPAddr{IP: ip}}
+		bound.DialContext = dialer.DialContext
+		if len(resolve) > 0 {
+			bound.DialContext = resolve.dialer(bound.DialContext)
+		}
+		roundTrippers = append(roundTrippers, bound)
+	}
+	return roundTrippers
+}
+
+// reportSourceIPs logs the accepted and throttled probes per source IP, to tell the per IP limits from the per token
+// limits
+func reportSourceIPs(name string, totals sampleTotals) {
+	var ips []string
+	for _, ip := range sourceIPs {
+		ips = append(ips, ip.String())
+	}
+	reportRotated("Source IP", name, ips, totals.sources)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// sshTunnelTimeout is how long the ssh process may take to open its SOCKS listener
+const sshTunnelTimeout = 30 * time.Second
+
+// sshStderr collects the error output of the ssh process, which is written concurrently with the dials
+type sshStderr struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (e *sshStderr) Write(p []byte) (int, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.buffer.Write(p)
+}
+
+func (e *sshStderr) String() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return strings.TrimSpace(e.buffer.String())
+}
+
+// sshSession is a single ssh session to the jump host multiplexing the probe connections as a local SOCKS proxy, the
+// tunneled connections are local TCP connections supporting the deadlines
+type sshSession struct {
+	bastion string
+	cmd     *exec.Cmd
+	stderr  *sshStderr
+	exited  chan struct{}
+	dialer  proxy.ContextDialer
+}
+
+// startSSHTunnel starts the ssh client of the system, so that its config, keys and agent apply, with a dynamic port
+// forwarding through the bastion, e.g. user@bastion
+func startSSHTunnel(bastion string) (*sshSession, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	tunnel := &sshSession{bastion: bastion, stderr: &sshStderr{}, exited: make(chan struct{})}
+	tunnel.cmd = exec.Command("ssh", "-N", "-o", "BatchMode=yes", "-o", "ExitOnForwardFailure=yes", "-D", addr, "--", bastion)
+	tunnel.cmd.Stderr = tunnel.stderr
+	err = tunnel.cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the ssh tunnel through %s: %v", bastion, err)
+	}
+	go func() {
+		tunnel.cmd.Wait()
+		close(tunnel.exited)
+	}()
+
+	deadline := time.Now().Add(sshTunnelTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			tunnel.close()
+			return nil, fmt.Errorf("the ssh tunnel through %s did not open within %v", bastion, sshTunnelTimeout)
+		}
+		select {
+		case <-tunnel.exited:
+			return nil, tunnel.failure()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	socks, err := proxy.SOCKS5("tcp", addr, nil, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		tunnel.close()
+		return nil, err
+	}
+	tunnel.dialer = socks.(proxy.ContextDialer)
+	return tunnel, nil
+}
+
+// failure returns why the ssh process exited
+func (t *sshSession) failure() error {
+	if message := t.stderr.String(); message != "" {
+		return fmt.Errorf("ssh tunnel through %s closed: %s", t.bastion, message)
+	}
+	return fmt.Errorf("ssh tunnel through %s closed", t.bastion)
+}
+
+func (t *sshSession) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := t.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		select {
+		case <-t.exited:
+			return nil, t.failure()
+		default:
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *sshSession) close() {
+	t.cmd.Process.Kill()
+	<-t.exited
+}
+
+// sharedTunnel is the ssh tunnel of all the probe clients, started with the first connection
+var sharedTunnel struct {
+	once   sync.Once
+	tunnel *sshSession
+	err    error
+}
+
+// sshTunnelDialer returns a dialer which tunnels every connection through the ssh session to the bastion
+func sshTunnelDialer(bastion string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		sharedTunnel.once.Do(func() {
+			sharedTunnel.tunnel, sharedTunnel.err = startSSHTunnel(bastion)
+			if sharedTunnel.err == nil {
+				addCleanup(sharedTunnel.tunnel.close)
+			}
+		})
+		if sharedTunnel.err != nil {
+			return nil, sharedTunnel.err
+		}
+		return sharedTunnel.tunnel.dial(ctx, network, addr)
+	}
+}
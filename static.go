@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// tokenEnv is the environment variable holding an already acquired token when the flag is not set
+const tokenEnv = "ARL_TOKEN"
+
+// StaticTokenSource hands out an already acquired token, such as one copied from Postman or another tool
+type StaticTokenSource struct {
+	token string
+	// file holds the token instead, it is read again on every acquisition to pick up a renewed token
+	file string
+}
+
+// NewStaticTokenSource creates a token source of the given token, or of the token stored in the file when the
+// token is empty
+func NewStaticTokenSource(token string, file string) *StaticTokenSource {
+	return &StaticTokenSource{
+		token: token,
+		file:  file,
+	}
+}
+
+// Token returns the static token
+func (ts *StaticTokenSource) Token() (string, error) {
+	if ts.file == "" {
+		return ts.token, nil
+	}
+	data, err := ioutil.ReadFile(ts.file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the token file: %v", err)
+	}
+	token := strings.TrimPrefix(strings.TrimSpace(string(data)), "Bearer ")
+	if token == "" {
+		return "", fmt.Errorf("the token file %s is empty", ts.file)
+	}
+	return token, nil
+}
+
+// Refresh returns the static token again, it cannot be renewed
+func (ts *StaticTokenSource) Refresh() (string, error) {
+	return ts.Token()
+}
+
+// readTokens reads one token per line, as piped by a credential helper, each token is a distinct identity
+func readTokens(input io.Reader) ([]string, error) {
+	var tokens []string
+	scanner := bufio.NewScanner(input)
+	// the JWTs with many group claims are longer than the default line limit
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		token := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "Bearer ")
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens read")
+	}
+	return tokens, nil
+}
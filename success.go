@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// successCodes are the status codes of the accepted requests, either codes or classes such as 2xx
+type successCodes []string
+
+func (sc *successCodes) String() string {
+	return strings.Join(*sc, ",")
+}
+
+func (sc *successCodes) Set(value string) error {
+	var codes successCodes
+	for _, code := range strings.Split(value, ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if len(code) != 3 || code[0] < '1' || code[0] > '5' || strings.Trim(code[1:], "0123456789x") != "" {
+			return fmt.Errorf("invalid status code %q, expected e.g. 200 or 2xx", code)
+		}
+		codes = append(codes, code)
+	}
+	*sc = codes
+	return nil
+}
+
+// matches returns true when the status code is one of the codes or in one of the classes
+func (sc successCodes) matches(statusCode int) bool {
+	status := strconv.Itoa(statusCode)
+	for _, code := range sc {
+		matched := len(status) == len(code)
+		for i := 0; matched && i < len(code); i++ {
+			matched = code[i] == 'x' || code[i] == status[i]
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// accepts returns true for the status codes of the accepted requests of the target, the -success-codes unless the
+// target has its own accepted codes, e.g. the 304 of the conditional requests
+func (target probeTarget) accepts(statusCode int) bool {
+	if target.accepted != nil {
+		return target.accepted.matches(statusCode)
+	}
+	return successStatuses.matches(statusCode)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuccessCodesSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		codes   successCodes
+		wantErr bool
+	}{
+		{value: "200", codes: successCodes{"200"}},
+		{value: "2xx, 304", codes: successCodes{"2xx", "304"}},
+		{value: "2XX", codes: successCodes{"2xx"}},
+		{value: "", wantErr: true},
+		{value: "20", wantErr: true},
+		{value: "600", wantErr: true},
+		{value: "2a0", wantErr: true},
+		{value: "200,", wantErr: true},
+	}
+	for _, test := range tests {
+		var codes successCodes
+		err := codes.Set(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) = %v, expected an error", test.value, codes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q) failed: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(codes, test.codes) {
+			t.Errorf("Set(%q) = %v, expected %v", test.value, codes, test.codes)
+		}
+	}
+}
+
+func TestSuccessCodesMatches(t *testing.T) {
+	tests := []struct {
+		codes   successCodes
+		status  int
+		matches bool
+	}{
+		{codes: successCodes{"200"}, status: 200, matches: true},
+		{codes: successCodes{"200"}, status: 201, matches: false},
+		{codes: successCodes{"2xx"}, status: 204, matches: true},
+		{codes: successCodes{"2xx"}, status: 304, matches: false},
+		{codes: successCodes{"2xx", "304"}, status: 304, matches: true},
+		{codes: successCodes{"30x"}, status: 307, matches: true},
+		{codes: successCodes{"30x"}, status: 310, matches: false},
+		{codes: successCodes{"2xx"}, status: 2000, matches: false},
+		{codes: nil, status: 200, matches: false},
+	}
+	for _, test := range tests {
+		if matches := test.codes.matches(test.status); matches != test.matches {
+			t.Errorf("%v.matches(%d) = %t, expected %t", test.codes, test.status, matches, test.matches)
+		}
+	}
+}
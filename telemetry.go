@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// tokenTelemetry accounts for the acquisitions of a pooled token, to correlate the throttling with the identities
+type tokenTelemetry struct {
+	Name         string        `json:"name"`
+	Acquisitions int           `json:"acquisitions"`
+	Refreshes    int           `json:"refreshes"`
+	Latency      time.Duration `json:"-"`
+	Expiry       time.Time     `json:"expiry,omitempty"`
+	ObjectID     string        `json:"object_id,omitempty"`
+	AppID        string        `json:"app_id,omitempty"`
+	// MeanLatency is the mean acquisition latency in milliseconds
+	MeanLatency float64 `json:"mean_latency_ms"`
+}
+
+// record accounts for a token acquired in the given time, refresh tells whether it renewed a previous token
+func (tt *tokenTelemetry) record(token string, latency time.Duration, refresh bool) {
+	tt.Acquisitions++
+	if refresh {
+		tt.Refreshes++
+	}
+	tt.Latency += latency
+	tt.MeanLatency = float64(tt.Latency) / float64(tt.Acquisitions) / float64(time.Millisecond)
+	tt.observe(token)
+}
+
+// observe reads the expiry and the identity claims of the token
+func (tt *tokenTelemetry) observe(token string) {
+	claims, ok := parseTokenClaims(token)
+	if !ok {
+		return
+	}
+	if claims.Exp != 0 {
+		tt.Expiry = time.Unix(claims.Exp, 0).UTC()
+	}
+	tt.ObjectID = claims.Oid
+	tt.AppID = firstNonEmpty(claims.Appid, claims.Azp)
+}
+
+// telemetry returns the telemetry of the tokens acquired so far, ordered by index
+func (p *tokenPool) telemetry() []tokenTelemetry {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var telemetry []tokenTelemetry
+	for i := 0; i < p.size; i++ {
+		if tt, ok := p.stats[i]; ok {
+			telemetry = append(telemetry, *tt)
+		}
+	}
+	return telemetry
+}
+
+// stat returns the telemetry of the token with the given index, the lock must be held
+func (p *tokenPool) stat(index int) *tokenTelemetry {
+	tt, ok := p.stats[index]
+	if !ok {
+		tt = &tokenTelemetry{Name: profiles.profile(index).name}
+		p.stats[index] = tt
+	}
+	return tt
+}
+
+// reportTokens logs the telemetry of the tokens
+func reportTokens(telemetry []tokenTelemetry) {
+	for _, tt := range telemetry {
+		expiry := "unknown"
+		if !tt.Expiry.IsZero() {
+			expiry = tt.Expiry.Format(time.RFC3339)
+		}
+		log.Printf("Token of %s: %d acquisitions (%d refreshes), mean acquisition latency %.0fms, expiry %s, object ID %s, app ID %s",
+			tt.Name, tt.Acquisitions, tt.Refreshes, tt.MeanLatency, expiry, firstNonEmpty(tt.ObjectID, "-"), firstNonEmpty(tt.AppID, "-"))
+	}
+}
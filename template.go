@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mathrand "math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+)
+
+// templateSeq is the sequence number of the rendered requests
+var templateSeq uint64
+
+// templateFuncs are the placeholders of the URLs and bodies rendered for every request, e.g. {{uuid}}, {{seq}},
+// {{rand}} or {{rand 1 100}}
+var templateFuncs = template.FuncMap{
+	"uuid": newUUID,
+	"seq": func() uint64 {
+		return atomic.AddUint64(&templateSeq, 1)
+	},
+	"rand": func(bounds ...int) (int, error) {
+		switch {
+		case len(bounds) == 0:
+			return mathrand.Int(), nil
+		case len(bounds) != 2:
+			return 0, fmt.Errorf("rand expects no bounds or the minimum and the maximum, got %d values", len(bounds))
+		case bounds[1] < bounds[0]:
+			return 0, fmt.Errorf("rand %d %d: the maximum is lower than the minimum", bounds[0], bounds[1])
+		}
+		return bounds[0] + mathrand.Intn(bounds[1]-bounds[0]+1), nil
+	},
+}
+
+// templates caches the parsed templates by their text
+var templates sync.Map
+
+// parseTemplate returns the parsed template of the text
+func parseTemplate(text string) (*template.Template, error) {
+	if cached, ok := templates.Load(text); ok {
+		return cached.(*template.Template), nil
+	}
+	parsed, err := template.New("probe").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templates.Store(text, parsed)
+	return parsed, nil
+}
+
+// renderTemplate renders the placeholders of the text, the text without any placeholder is returned as is
+func renderTemplate(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	parsed, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	err = parsed.Execute(&rendered, nil)
+	if err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// renderTarget renders the placeholders of the URL and the body of the target for a single request
+func renderTarget(target probeTarget) (probeTarget, error) {
+	var err error
+	target.URL, err = renderTemplate(target.URL)
+	if err != nil {
+		return target, fmt.Errorf("failed to render the URL: %v", err)
+	}
+	if bytes.Contains(target.body, []byte("{{")) && !isMultipart(target.header.Get("Content-Type")) {
+		body, err := renderTemplate(string(target.body))
+		if err != nil {
+			return target, fmt.Errorf("failed to render the body: %v", err)
+		}
+		target.body = []byte(body)
+	}
+	return target, nil
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var id [16]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		text    string
+		check   func(rendered string) bool
+		wantErr bool
+	}{
+		{text: "https://api.contoso.com/orders", check: func(rendered string) bool {
+			return rendered == "https://api.contoso.com/orders"
+		}},
+		{text: "{{uuid}}", check: uuid.MatchString},
+		{text: "{{rand}}", check: func(rendered string) bool {
+			n, err := strconv.Atoi(rendered)
+			return err == nil && n >= 0
+		}},
+		{text: "{{rand 5 5}}", check: func(rendered string) bool { return rendered == "5" }},
+		{text: "{{rand 1 3}}", check: func(rendered string) bool {
+			n, err := strconv.Atoi(rendered)
+			return err == nil && n >= 1 && n <= 3
+		}},
+		{text: "{{seq}}", check: func(rendered string) bool {
+			n, err := strconv.ParseUint(rendered, 10, 64)
+			return err == nil && n > 0
+		}},
+		{text: "{{rand 1}}", wantErr: true},
+		{text: "{{rand 3 1}}", wantErr: true},
+		{text: "{{unknown}}", wantErr: true},
+		{text: "{{uuid", wantErr: true},
+	}
+	for _, test := range tests {
+		rendered, err := renderTemplate(test.text)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("renderTemplate(%q) = %q, expected an error", test.text, rendered)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("renderTemplate(%q) failed: %v", test.text, err)
+			continue
+		}
+		if !test.check(rendered) {
+			t.Errorf("renderTemplate(%q) = %q, unexpected value", test.text, rendered)
+		}
+	}
+}
+
+func TestTemplateSeq(t *testing.T) {
+	first, err := renderTemplate("{{seq}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := renderTemplate("{{seq}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.ParseUint(first, 10, 64)
+	if second != strconv.FormatUint(n+1, 10) {
+		t.Errorf("the sequence rendered %s after %s", second, first)
+	}
+}
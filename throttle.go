@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// isThrottled returns true for a 429 response or for a 503 response carrying a Retry-After header
+func isThrottled(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// throttleClass accumulates the throttled responses with the same status code
+type throttleClass struct {
+	responses      int
+	withRetryAfter int
+	minRetryAfter  time.Duration
+	maxRetryAfter  time.Duration
+	sumRetryAfter  time.Duration
+}
+
+// throttleClasses tracks the throttled responses per status code since their retry semantics differ
+type throttleClasses struct {
+	lock    sync.Mutex
+	classes map[int]*throttleClass
+}
+
+func newThrottleClasses() *throttleClasses {
+	return &throttleClasses{classes: make(map[int]*throttleClass)}
+}
+
+// record registers a throttled response and returns its Retry-After delay
+func (tc *throttleClasses) record(resp *http.Response) time.Duration {
+	retryAfter := parseRetryAfter(resp.Header)
+
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	class, ok := tc.classes[resp.StatusCode]
+	if !ok {
+		class = &throttleClass{}
+		tc.classes[resp.StatusCode] = class
+	}
+	class.responses++
+	if retryAfter > 0 {
+		if class.withRetryAfter == 0 || retryAfter < class.minRetryAfter {
+			class.minRetryAfter = retryAfter
+		}
+		if retryAfter > class.maxRetryAfter {
+			class.maxRetryAfter = retryAfter
+		}
+		class.sumRetryAfter += retryAfter
+		class.withRetryAfter++
+	}
+	return retryAfter
+}
+
+// report logs which throttle classes the service emitted
+func (tc *throttleClasses) report() {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	var statusCodes []int
+	for statusCode := range tc.classes {
+		statusCodes = append(statusCodes, statusCode)
+	}
+	sort.Ints(statusCodes)
+	for _, statusCode := range statusCodes {
+		class := tc.classes[statusCode]
+		if class.withRetryAfter == 0 {
+			log.Printf("Throttled with %d %s: %d responses without Retry-After",
+				statusCode, http.StatusText(statusCode), class.responses)
+			continue
+		}
+		log.Printf("Throttled with %d %s: %d responses, Retry-After min %v, avg %v, max %v (%d without)",
+			statusCode, http.StatusText(statusCode), class.responses, class.minRetryAfter,
+			class.sumRetryAfter/time.Duration(class.withRetryAfter), class.maxRetryAfter,
+			class.responses-class.withRetryAfter)
+	}
+}
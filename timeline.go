@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type timelineEvent struct {
+	at      time.Time
+	message string
+}
+
+// timeline records the notable events of a measurement
+type timeline struct {
+	lock   sync.Mutex
+	events []timelineEvent
+}
+
+func (t *timeline) add(format string, args ...interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, timelineEvent{at: time.Now(), message: fmt.Sprintf(format, args...)})
+}
+
+// snapshot returns a copy of the events recorded so far
+func (t *timeline) snapshot() []timelineEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]timelineEvent(nil), t.events...)
+}
+
+// report logs the events relative to the start of the measurement
+func (t *timeline) report(name string, start time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	log.Printf("Timeline of %s:", name)
+	for _, event := range t.events {
+		log.Printf("  %+10.3fs %s", event.at.Sub(start).Seconds(), event.message)
+	}
+}
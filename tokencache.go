@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+)
+
+// tokenCacheEntry is the MSAL cache persisted for a client of a tenant, along with the resource and the expiry of
+// its last access token
+type tokenCacheEntry struct {
+	TenantID  string    `json:"tenant_id"`
+	ClientID  string    `json:"client_id"`
+	Resource  string    `json:"resource"`
+	ExpiresOn time.Time `json:"expires_on"`
+	// MSAL is the opaque serialization of the MSAL cache holding the account, the access and the refresh tokens
+	MSAL json.RawMessage `json:"msal,omitempty"`
+}
+
+// tokenCache persists the acquired tokens in a file readable only by the current user
+type tokenCache struct {
+	lock sync.Mutex
+	path string
+}
+
+func newTokenCache(path string) *tokenCache {
+	return &tokenCache{path: path}
+}
+
+// defaultTokenCachePath returns the location of the token cache in the home directory of the user
+func defaultTokenCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".arl", "tokens.json")
+}
+
+func (tc *tokenCache) load() ([]tokenCacheEntry, error) {
+	data, err := ioutil.ReadFile(tc.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []tokenCacheEntry
+	err = json.Unmarshal(data, &entries)
+	return entries, err
+}
+
+func (tc *tokenCache) save(entries []tokenCacheEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(tc.path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(tc.path), "tokens")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), tc.path)
+}
+
+// update applies a change to the entry of a client, adding it when missing
+func (tc *tokenCache) update(tenantID string, clientID string, change func(entry *tokenCacheEntry)) error {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	entries, err := tc.load()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		if entries[i].TenantID == tenantID && entries[i].ClientID == clientID {
+			change(&entries[i])
+			return tc.save(entries)
+		}
+	}
+	entry := tokenCacheEntry{TenantID: tenantID, ClientID: clientID}
+	change(&entry)
+	return tc.save(append(entries, entry))
+}
+
+// describe records the resource and the expiry of the last access token of a client
+func (tc *tokenCache) describe(tenantID string, clientID string, resource string, expiresOn time.Time) error {
+	return tc.update(tenantID, clientID, func(entry *tokenCacheEntry) {
+		entry.Resource = resource
+		entry.ExpiresOn = expiresOn
+	})
+}
+
+// partition returns the MSAL cache storage of a client
+func (tc *tokenCache) partition(tenantID string, clientID string) cache.ExportReplace {
+	return &cachePartition{cache: tc, tenantID: tenantID, clientID: clientID}
+}
+
+// cachePartition loads and stores the MSAL cache of a client in the token cache file
+type cachePartition struct {
+	cache    *tokenCache
+	tenantID string
+	clientID string
+}
+
+// Replace loads the persisted MSAL cache of the client
+func (cp *cachePartition) Replace(ctx context.Context, unmarshaler cache.Unmarshaler, hints cache.ReplaceHints) error {
+	entries, err := cp.cache.entries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.TenantID == cp.tenantID && entry.ClientID == cp.clientID && len(entry.MSAL) > 0 {
+			return unmarshaler.Unmarshal(entry.MSAL)
+		}
+	}
+	return nil
+}
+
+// Export persists the MSAL cache of the client
+func (cp *cachePartition) Export(ctx context.Context, marshaler cache.Marshaler, hints cache.ExportHints) error {
+	data, err := marshaler.Marshal()
+	if err != nil {
+		return err
+	}
+	return cp.cache.update(cp.tenantID, cp.clientID, func(entry *tokenCacheEntry) {
+		entry.MSAL = data
+	})
+}
+
+// entries returns all the cached tokens
+func (tc *tokenCache) entries() ([]tokenCacheEntry, error) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return tc.load()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenResponse is the response of an OAuth2 token endpoint, the AAD v1 endpoint returns the numbers as strings
+type tokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	RefreshToken     string      `json:"refresh_token"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	TokenType        string      `json:"token_type"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// requestToken posts a grant to an OAuth2 token endpoint and returns the issued token
+func requestToken(endpoint string, form url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var token tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to parse the token response: %v", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint responded with %s", resp.Status)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in the token response")
+	}
+	return &token, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before its expiry a pooled token is evicted
+const tokenExpiryMargin = time.Minute
+
+type poolEntry struct {
+	token  string
+	expiry time.Time
+}
+
+func (e *poolEntry) expired() bool {
+	return !e.expiry.IsZero() && time.Now().Add(tokenExpiryMargin).After(e.expiry)
+}
+
+// tokenPool hands out a token per index, acquiring it lazily from the token source on first use and again
+// once it expires
+type tokenPool struct {
+	// lock guards the entries and the telemetry, it is not held while a token is acquired
+	lock   sync.Mutex
+	source TokenSource
+	// sourceLock serializes the acquisitions from the single source
+	sourceLock sync.Mutex
+	// sources acquires every token from its own identity instead of the single source, in parallel
+	sources  []TokenSource
+	size     int
+	acquired bool
+	entries  map[int]*poolEntry
+	stats    map[int]*tokenTelemetry
+	// acquiring serializes the acquisitions of the same index
+	acquiring map[int]*sync.Mutex
+}
+
+func newTokenPool(source TokenSource, size int) *tokenPool {
+	return &tokenPool{
+		source:    source,
+		size:      size,
+		entries:   make(map[int]*poolEntry),
+		stats:     make(map[int]*tokenTelemetry),
+		acquiring: make(map[int]*sync.Mutex),
+	}
+}
+
+func newStaticTokenPool(tokens []string) *tokenPool {
+	pool := newTokenPool(nil, len(tokens))
+	for i, token := range tokens {
+		pool.entries[i] = newPoolEntry(token)
+		pool.stat(i).observe(token)
+	}
+	return pool
+}
+
+// newMultiSourceTokenPool creates a pool where every token is acquired from a distinct token source
+func newMultiSourceTokenPool(sources []TokenSource) *tokenPool {
+	pool := newTokenPool(nil, len(sources))
+	pool.sources = sources
+	return pool
+}
+
+func newPoolEntry(token string) *poolEntry {
+	entry := &poolEntry{token: token}
+	if expiry, ok := tokenExpiry(token); ok {
+		entry.expiry = expiry
+	}
+	return entry
+}
+
+// get returns the token with the given index, acquiring a new one when missing or expired
+func (p *tokenPool) get(index int) (string, error) {
+	if index < 0 || index >= p.size {
+		return "", fmt.Errorf("token index %d out of the pool of %d tokens", index, p.size)
+	}
+	token, ok := p.cached(index)
+	if ok {
+		return token, nil
+	}
+	p.lock.Lock()
+	acquiring, ok := p.acquiring[index]
+	if !ok {
+		acquiring = &sync.Mutex{}
+		p.acquiring[index] = acquiring
+	}
+	p.lock.Unlock()
+	acquiring.Lock()
+	defer acquiring.Unlock()
+	// another worker may have acquired the token in the meantime
+	token, ok = p.cached(index)
+	if ok {
+		return token, nil
+	}
+
+	var source TokenSource
+	var renewal bool
+	switch {
+	case p.sources != nil:
+		// an identity already signed in renews its token instead of getting its cached token back
+		source = p.sources[index]
+		p.lock.Lock()
+		renewal = p.stat(index).Acquisitions > 0
+		p.lock.Unlock()
+	case p.source != nil:
+		source = p.source
+		p.sourceLock.Lock()
+		defer p.sourceLock.Unlock()
+		p.lock.Lock()
+		renewal = p.acquired
+		p.lock.Unlock()
+	default:
+		return "", fmt.Errorf("token %d expired and cannot be renewed", index)
+	}
+
+	var err error
+	start := time.Now()
+	if !renewal {
+		token, err = source.Token()
+	} else {
+		token, err = source.Refresh()
+	}
+	if err != nil {
+		return "", err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	err = p.checkDistinct(index, token)
+	if err != nil {
+		return "", err
+	}
+	stat := p.stat(index)
+	stat.record(token, time.Since(start), stat.Acquisitions > 0)
+	p.acquired = true
+	p.entries[index] = newPoolEntry(token)
+	return token, nil
+}
+
+// cached returns the pooled token of the index unless it is missing or expired
+func (p *tokenPool) cached(index int) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.evictExpired()
+	entry, ok := p.entries[index]
+	if !ok {
+		return "", false
+	}
+	return entry.token, true
+}
+
+// checkDistinct refuses a token already pooled at another index, e.g. the cached token returned again by the managed
+// identity, the Azure CLI or a static token, which would not be a dedicated token
+func (p *tokenPool) checkDistinct(index int, token string) error {
+	for other, entry := range p.entries {
+		if other != index && entry.token == token {
+			return fmt.Errorf("token %d is the same as token %d, the token source does not issue distinct tokens, use -num-tokens 1", index, other)
+		}
+	}
+	return nil
+}
+
+// renew acquires a new token for the index, even though the current one has not expired yet
+func (p *tokenPool) renew(index int) (string, error) {
+	p.lock.Lock()
+	delete(p.entries, index)
+	p.lock.Unlock()
+	return p.get(index)
+}
+
+// evictExpired drops the expired tokens so that they get renewed on their next use
+func (p *tokenPool) evictExpired() {
+	for index, entry := range p.entries {
+		if entry.expired() {
+			delete(p.entries, index)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package tokensource is the registry of the token sources of arl, which lets other packages provide their own
+// authentication modes selected with -auth <name>
+package tokensource
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TokenSource interface which should be implemented by an access token provider
+type TokenSource interface {
+	Token() (string, error)
+	Refresh() (string, error)
+}
+
+// Factory creates a custom token source from its configuration
+type Factory func(cfg map[string]string) (TokenSource, error)
+
+var registry = struct {
+	lock      sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register registers a custom token source selected with -auth <name>, usually from the init function of a package
+// imported by a fork of arl. The factory receives the -auth-config settings together with the resource, audience,
+// tenant_id, client_id and scopes of the flags. The built-in authentication modes take precedence over the custom
+// ones.
+func Register(name string, factory Factory) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.factories[name]; ok {
+		panic(fmt.Sprintf("token source %q registered twice", name))
+	}
+	registry.factories[name] = factory
+}
+
+// Lookup returns the factory of the token source registered with the name
+func Lookup(name string) (Factory, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	factory, ok := registry.factories[name]
+	return factory, ok
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+)
+
+// loadUserAgents returns the -user-agent followed by the ones of the file, one per line
+func loadUserAgents(userAgent string, file string) ([]string, error) {
+	var userAgents []string
+	if userAgent != "" {
+		userAgents = append(userAgents, userAgent)
+	}
+	if file == "" {
+		return userAgents, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			userAgents = append(userAgents, line)
+		}
+	}
+	return userAgents, scanner.Err()
+}
+
+// workerUserAgent returns the User-Agent of the probes of the worker, empty for the default one of Go
+func workerUserAgent(worker int) string {
+	if len(userAgents) == 0 {
+		return ""
+	}
+	return userAgents[worker%len(userAgents)]
+}
+
+// contextUserAgent returns the User-Agent of the worker sending the request, the other requests use the first one
+func contextUserAgent(ctx context.Context) string {
+	worker, _ := ctx.Value(workerKey{}).(int)
+	return workerUserAgent(worker)
+}
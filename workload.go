@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+// federatedAudience is the audience of the federated tokens exchanged with Azure AD
+const federatedAudience = "api://AzureADTokenExchange"
+
+// WorkloadIdentityTokenSource exchanges a federated token, such as a Kubernetes projected service account token
+// or a GitHub Actions OIDC token, for an Azure access token
+type WorkloadIdentityTokenSource struct {
+	app      *confidentialClient
+	resource string
+	// scopes are requested instead of the static permissions of the resource when set
+	scopes []string
+	// tokenFile is the federated token file, which is read again on every exchange since it is rotated
+	tokenFile string
+}
+
+// NewWorkloadIdentityTokenSource creates a token source exchanging the federated token of the file, or the GitHub
+// Actions OIDC token when the file is empty
+func NewWorkloadIdentityTokenSource(tenantID string, clientID string, resource string, tokenFile string) (*WorkloadIdentityTokenSource, error) {
+	if tokenFile == "" && os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") == "" {
+		return nil, errors.New("no federated token, set AZURE_FEDERATED_TOKEN_FILE or run in GitHub Actions with the id-token permission")
+	}
+	ts := &WorkloadIdentityTokenSource{
+		resource:  resource,
+		tokenFile: tokenFile,
+	}
+	// the federated token is the client assertion of the confidential client
+	credential := confidential.NewCredFromAssertionCallback(func(ctx context.Context, options confidential.AssertionRequestOptions) (string, error) {
+		return ts.federatedToken()
+	})
+	app, err := newConfidentialClient(tenantID, clientID, credential)
+	if err != nil {
+		return nil, err
+	}
+	ts.app = app
+	return ts, nil
+}
+
+// federatedToken returns the current federated token
+func (ts *WorkloadIdentityTokenSource) federatedToken() (string, error) {
+	if ts.tokenFile != "" {
+		data, err := ioutil.ReadFile(ts.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the federated token: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return githubOIDCToken()
+}
+
+// githubOIDCToken requests the OIDC token of the GitHub Actions job
+func githubOIDCToken() (string, error) {
+	requestURL, err := url.Parse(os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"))
+	if err != nil {
+		return "", err
+	}
+	query := requestURL.Query()
+	query.Set("audience", federatedAudience)
+	requestURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub OIDC token request responded with %s", resp.Status)
+	}
+	var token struct {
+		Value string `json:"value"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	return token.Value, nil
+}
+
+// Token exchanges the federated token for an access token
+func (ts *WorkloadIdentityTokenSource) Token() (string, error) {
+	scopes := ts.scopes
+	if len(scopes) == 0 {
+		scopes = resourceScopes(ts.resource)
+	}
+	result, err := ts.app.client.AcquireTokenByCredential(context.Background(), scopes)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange the federated token: %v", err)
+	}
+	return result.AccessToken, nil
+}
+
+// Refresh exchanges the federated token again, there is no refresh token
+func (ts *WorkloadIdentityTokenSource) Refresh() (string, error) {
+	return ts.Token()
+}